@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// benchCfg is the bench section of gopkg.yaml
+type benchCfg struct {
+	// packages to benchmark, relative to src, by default all of them
+	Packages []string `yaml:"packages"`
+	// runs of each benchmark, at least 5 are needed for significance
+	Count int `yaml:"count"`
+	// fail when a benchmark is significantly slower by more than
+	// this many percent, 0 never fails
+	Threshold float64 `yaml:"threshold"`
+}
+
+const benchAlpha = 0.05
+
+var benchDir = toPath(".gopkg", "bench")
+
+// parseBench returns the ns/op samples of each benchmark in go test
+// -bench output, keyed by package and name
+func parseBench(r io.Reader) (map[string][]float64, []string) {
+	samples := map[string][]float64{}
+	var names []string
+	pkg := ""
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "pkg: ") {
+			pkg = strings.TrimSpace(line[5:])
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		for i := 2; i+1 < len(fields); i += 2 {
+			if fields[i+1] != "ns/op" {
+				continue
+			}
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				break
+			}
+			name := fields[0]
+			if pkg != "" {
+				name = pkg + "." + name
+			}
+			if _, ok := samples[name]; !ok {
+				names = append(names, name)
+			}
+			samples[name] = append(samples[name], v)
+		}
+	}
+	return samples, names
+}
+
+func mean(xs []float64) float64 {
+	sum := 0.0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+// mannWhitney returns the two-sided p-value of the Mann-Whitney U test,
+// exact without ties and from the normal approximation with ties
+func mannWhitney(a, b []float64) float64 {
+	n1, n2 := len(a), len(b)
+	type obs struct {
+		v     float64
+		fromA bool
+	}
+	all := make([]obs, 0, n1+n2)
+	for _, v := range a {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range b {
+		all = append(all, obs{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// midranks
+	ranks := make([]float64, len(all))
+	ties := false
+	tieSum := 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		for k := i; k < j; k++ {
+			ranks[k] = float64(i+j+1) / 2
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tieSum += t*t*t - t
+		}
+		i = j
+	}
+	r1 := 0.0
+	for i, o := range all {
+		if o.fromA {
+			r1 += ranks[i]
+		}
+	}
+	u := r1 - float64(n1*(n1+1))/2
+	u = math.Min(u, float64(n1*n2)-u)
+
+	if !ties {
+		// count the arrangements with U <= u
+		var count func(n1, n2, u int) float64
+		memo := map[[3]int]float64{}
+		count = func(n1, n2, u int) float64 {
+			if u < 0 {
+				return 0
+			}
+			if n1 == 0 || n2 == 0 {
+				return 1
+			}
+			key := [3]int{n1, n2, u}
+			if c, ok := memo[key]; ok {
+				return c
+			}
+			c := count(n1-1, n2, u-n2) + count(n1, n2-1, u)
+			memo[key] = c
+			return c
+		}
+		total := math.Exp(lgamma(n1+n2+1) - lgamma(n1+1) - lgamma(n2+1))
+		return math.Min(1, 2*count(n1, n2, int(u))/total)
+	}
+	n := float64(n1 + n2)
+	mu := float64(n1*n2) / 2
+	sigma := math.Sqrt(float64(n1*n2) / 12 * (n + 1 - tieSum/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	z := (u - mu + 0.5) / sigma
+	return math.Min(1, math.Erfc(-z/math.Sqrt2))
+}
+
+func lgamma(n int) float64 {
+	v, _ := math.Lgamma(float64(n))
+	return v
+}
+
+// compareBench prints the change of each benchmark and returns the
+// significant regressions larger than threshold percent
+func compareBench(old, cur map[string][]float64, names []string, threshold float64) []string {
+	var regressions []string
+	fmt.Printf("\n%-50s  %12s  %12s  %8s  %s\n", "BENCHMARK", "OLD ns/op", "NEW ns/op", "DELTA", "P")
+	for _, name := range names {
+		a, b := old[name], cur[name]
+		if len(a) == 0 || len(b) == 0 {
+			continue
+		}
+		ma, mb := mean(a), mean(b)
+		delta := (mb - ma) / ma * 100
+		p := mannWhitney(a, b)
+		d := fmt.Sprintf("%+.2f%%", delta)
+		if p >= benchAlpha {
+			d = "~"
+		} else if delta > 0 {
+			d = yellowText(d)
+		} else {
+			d = greenText(d)
+		}
+		fmt.Printf("%-50s  %12.1f  %12.1f  %8s  p=%.3f n=%d+%d\n", name, ma, mb, d, p, len(a), len(b))
+		if threshold > 0 && p < benchAlpha && delta > threshold {
+			regressions = append(regressions, fmt.Sprintf("%s %+.2f%%", name, delta))
+		}
+	}
+	return regressions
+}
+
+func benchCommand(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	save := fs.String("save", "latest", "save results as .gopkg/bench/<name>")
+	baseline := fs.String("baseline", "baseline", "compare with .gopkg/bench/<name>")
+	count := fs.Int("count", 0, "runs of each benchmark")
+	deps := fs.Bool("deps", false, "also benchmark src/packages")
+	features := fs.String("features", "", "comma separated features to enable")
+	fs.Parse(args)
+	projectFeatures = parseFeatures(*features)
+	pattern := "."
+	if fs.NArg() > 0 {
+		pattern = fs.Arg(0)
+	}
+
+	p, err := getDeps(".")
+	if err != nil {
+		return err
+	}
+	if *count == 0 {
+		*count = p.Bench.Count
+	}
+	if *count == 0 {
+		*count = 5
+	}
+	dirs, err := testPackages(p.Bench.Packages, *deps)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	for _, dir := range dirs {
+		if !hasTests(dir) {
+			continue
+		}
+		benchArgs := append([]string{"test"}, tagsFlag()...)
+		benchArgs = append(benchArgs, "-run", "^$", "-bench", pattern,
+			"-benchmem", "-count", strconv.Itoa(*count), toPath(".", dir))
+		cmd := exec.Command("go", benchArgs...)
+		cmd.Stdout = io.MultiWriter(os.Stdout, &out)
+		cmd.Stderr = os.Stdout
+		err = cmd.Run()
+		if err != nil {
+			return fmt.Errorf("%s: benchmarks failed: %v", dir, err)
+		}
+	}
+
+	err = os.MkdirAll(benchDir, dirPerm)
+	if err != nil {
+		return err
+	}
+	file := toPath(benchDir, *save)
+	err = ioutil.WriteFile(file, out.Bytes(), filePerm)
+	if err != nil {
+		return err
+	}
+	fmt.Println(greenText("Saved"), file)
+
+	baseFile := toPath(benchDir, *baseline)
+	if *baseline == *save || !fileExists(baseFile) {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(baseFile)
+	if err != nil {
+		return err
+	}
+	old, _ := parseBench(bytes.NewReader(buf))
+	cur, names := parseBench(bytes.NewReader(out.Bytes()))
+	regressions := compareBench(old, cur, names, p.Bench.Threshold)
+	if len(regressions) > 0 {
+		return errors.New("regressions over " + strconv.FormatFloat(p.Bench.Threshold, 'f', -1, 64) +
+			"%:\n  " + strings.Join(regressions, "\n  "))
+	}
+	return nil
+}
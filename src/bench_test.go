@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMannWhitneyExact(t *testing.T) {
+	tests := []struct {
+		a, b []float64
+		want float64
+	}{
+		// U = 0, one arrangement of C(6,3) = 20 on each side
+		{[]float64{1, 2, 3}, []float64{4, 5, 6}, 2.0 / 20},
+		{[]float64{4, 5, 6}, []float64{1, 2, 3}, 2.0 / 20},
+		// U = 0 with C(10,5) = 252 arrangements
+		{[]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 2.0 / 252},
+		// U = 3, 7 of 20 arrangements have U <= 3
+		{[]float64{1, 3, 5}, []float64{2, 4, 6}, 14.0 / 20},
+		// U is at the middle of the distribution
+		{[]float64{1, 4, 5, 8}, []float64{2, 3, 6, 7}, 1},
+	}
+	for _, tt := range tests {
+		if got := mannWhitney(tt.a, tt.b); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("mannWhitney(%v, %v) = %g, want %g", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMannWhitneyTies(t *testing.T) {
+	// U = 0.5, mu = 12.5, sigma = sqrt(25/12 * (11 - 30/90)) with the
+	// tie correction, z = -2.4395 with the continuity correction
+	got := mannWhitney([]float64{1, 1, 2, 2, 3}, []float64{3, 4, 4, 5, 5})
+	if want := 0.014706853890834998; math.Abs(got-want) > 1e-12 {
+		t.Errorf("mannWhitney with ties = %g, want %g", got, want)
+	}
+	// all equal, no evidence of a difference
+	if got := mannWhitney([]float64{10, 10, 10}, []float64{10, 10, 10}); got != 1 {
+		t.Errorf("mannWhitney of equal samples = %g, want 1", got)
+	}
+}
+
+const benchOutput = `goos: linux
+goarch: amd64
+pkg: example.com/parse
+cpu: Intel(R) Xeon(R) Processor
+BenchmarkParse-8     	  100000	     12034 ns/op	  85.12 MB/s	    4096 B/op	      12 allocs/op
+BenchmarkParse-8     	  100000	     11890 ns/op	  86.15 MB/s	    4096 B/op	      12 allocs/op
+BenchmarkSmall/n=10-8	 5000000	       243.5 ns/op	       0 B/op	       0 allocs/op
+PASS
+ok  	example.com/parse	3.125s
+pkg: util
+BenchmarkAdd 	367711664	         3.366 ns/op	       0 B/op	       0 allocs/op
+--- FAIL: BenchmarkBroken
+    broken_test.go:9: oops
+PASS
+ok  	util	3.125s
+`
+
+func TestParseBench(t *testing.T) {
+	samples, names := parseBench(strings.NewReader(benchOutput))
+	wantNames := []string{
+		"example.com/parse.BenchmarkParse-8",
+		"example.com/parse.BenchmarkSmall/n=10-8",
+		"util.BenchmarkAdd",
+	}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("names = %q, want %q", names, wantNames)
+	}
+	wantSamples := map[string][]float64{
+		"example.com/parse.BenchmarkParse-8":      {12034, 11890},
+		"example.com/parse.BenchmarkSmall/n=10-8": {243.5},
+		"util.BenchmarkAdd":                       {3.366},
+	}
+	if !reflect.DeepEqual(samples, wantSamples) {
+		t.Errorf("samples = %v, want %v", samples, wantSamples)
+	}
+}
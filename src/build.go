@@ -0,0 +1,506 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// target is a GOOS/GOARCH pair to cross-compile for. In gopkg.yaml it is
+// either "linux/arm64" or a map with the settings of the target:
+//
+//	targets:
+//	  - linux/amd64
+//	  - target: linux/arm
+//	    env: {GOARM: "7"}
+//	    tags: [embedded]
+//	    cgo: false
+type target struct {
+	OS   string
+	Arch string
+	Env  map[string]string
+	Tags []string
+	Cgo  *bool
+}
+
+func parseTarget(s string) (*target, error) {
+	i := strings.IndexByte(s, '/')
+	if i <= 0 || i == len(s)-1 {
+		return nil, errors.New("invalid target " + s + ", want os/arch")
+	}
+	return &target{OS: s[:i], Arch: s[i+1:]}, nil
+}
+
+func (t *target) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if unmarshal(&s) == nil {
+		pt, err := parseTarget(s)
+		if err != nil {
+			return err
+		}
+		*t = *pt
+		return nil
+	}
+	var full struct {
+		Target string            `yaml:"target"`
+		Env    map[string]string `yaml:"env"`
+		Tags   []string          `yaml:"tags"`
+		Cgo    *bool             `yaml:"cgo"`
+	}
+	err := unmarshal(&full)
+	if err != nil {
+		return err
+	}
+	pt, err := parseTarget(full.Target)
+	if err != nil {
+		return err
+	}
+	*t = target{OS: pt.OS, Arch: pt.Arch, Env: full.Env, Tags: full.Tags, Cgo: full.Cgo}
+	return nil
+}
+
+func (t *target) String() string {
+	return t.OS + "/" + t.Arch
+}
+
+// output returns dist/<name>-<os>-<arch>
+func (t *target) output(name string) string {
+	return toPath("dist", name+"-"+t.OS+"-"+t.Arch) + t.exeSuffix()
+}
+
+func (t *target) exeSuffix() string {
+	if t.OS == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+func (t *target) environ() []string {
+	env := []string{"GOOS=" + t.OS, "GOARCH=" + t.Arch}
+	if t.Cgo != nil {
+		if *t.Cgo {
+			env = append(env, "CGO_ENABLED=1")
+		} else {
+			env = append(env, "CGO_ENABLED=0")
+		}
+	}
+	keys := make([]string, 0, len(t.Env))
+	for k := range t.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+t.Env[k])
+	}
+	return env
+}
+
+// findTarget returns the target called s in p, or a plain one if p
+// does not declare it
+func findTarget(p *gopkgCfg, s string) (*target, error) {
+	for i := range p.Targets {
+		if p.Targets[i].String() == s {
+			return &p.Targets[i], nil
+		}
+	}
+	return parseTarget(s)
+}
+
+// profile holds the go build flags of a build mode
+type profile struct {
+	Tags     []string `yaml:"tags"`
+	Gcflags  string   `yaml:"gcflags"`
+	Ldflags  string   `yaml:"ldflags"`
+	Trimpath bool     `yaml:"trimpath"`
+	Race     bool     `yaml:"race"`
+}
+
+// defaultProfiles can be overridden by the profiles section of gopkg.yaml
+var defaultProfiles = map[string]profile{
+	"dev":     {},
+	"release": {Trimpath: true, Ldflags: "-s -w"},
+}
+
+func findProfile(p *gopkgCfg, name string) (*profile, error) {
+	if prof, ok := p.Profiles[name]; ok {
+		return &prof, nil
+	}
+	if prof, ok := defaultProfiles[name]; ok {
+		return &prof, nil
+	}
+	return nil, errors.New("unknown profile: " + name)
+}
+
+// bin is a main package under src, built into a binary called Name
+type bin struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+}
+
+// binaries returns the bins section, or p.Name built from src itself
+func (p *gopkgCfg) binaries() []bin {
+	if len(p.Bins) == 0 {
+		return []bin{{Name: p.Name}}
+	}
+	return p.Bins
+}
+
+// selectBins returns the bins called names, all bins if names is empty
+func selectBins(p *gopkgCfg, names []string) ([]bin, error) {
+	if len(names) == 0 {
+		return p.binaries(), nil
+	}
+	var bins []bin
+	for _, name := range names {
+		found := false
+		for _, b := range p.binaries() {
+			if b.Name == name {
+				bins = append(bins, b)
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("unknown bin: " + name)
+		}
+	}
+	return bins, nil
+}
+
+// buildCmd is a go build invocation
+type buildCmd struct {
+	output string
+	pkg    string
+	// source files given instead of pkg
+	files []string
+	flags []string
+	// extra environment, KEY=VALUE
+	env []string
+}
+
+// newBuildCmd returns the command building bn for t, or for the host
+// if t is nil
+func newBuildCmd(p *gopkgCfg, bn bin, t *target, prof *profile, data *stampData) (*buildCmd, error) {
+	b := &buildCmd{output: bn.Name, pkg: toPath(".", "src")}
+	if bn.Path != "" {
+		b.pkg = toPath(".", "src", bn.Path)
+	} else if reproducible {
+		// src itself has the import path _/<absolute path>, which ends up
+		// in the binary, while named files are always command-line-arguments
+		files, err := pkgFiles(b.pkg)
+		if err != nil {
+			return nil, err
+		}
+		b.files = files
+	}
+	tags := prof.Tags
+	if t != nil {
+		b.output = t.output(bn.Name)
+		b.env = t.environ()
+		tags = append(append([]string{}, tags...), t.Tags...)
+	}
+	if len(featureTags) > 0 {
+		tags = append(append([]string{}, tags...), featureTags...)
+	}
+	if len(tags) > 0 {
+		b.flags = append(b.flags, "-tags", strings.Join(tags, ","))
+	}
+	if prof.Gcflags != "" {
+		b.flags = append(b.flags, "-gcflags", prof.Gcflags)
+	}
+	ldflags, err := stampFlags(p, data)
+	if err != nil {
+		return nil, err
+	}
+	if prof.Ldflags != "" {
+		ldflags = strings.TrimSpace(prof.Ldflags + " " + ldflags)
+	}
+	if ldflags != "" {
+		b.flags = append(b.flags, "-ldflags", ldflags)
+	}
+	if prof.Trimpath {
+		b.flags = append(b.flags, "-trimpath")
+	}
+	if prof.Race {
+		b.flags = append(b.flags, "-race")
+	}
+	return b, nil
+}
+
+// buildCmds returns the commands building each bin for each target,
+// a nil targets builds for the host
+func buildCmds(p *gopkgCfg, bins []bin, targets []*target, prof *profile, data *stampData) ([]*buildCmd, error) {
+	if targets == nil {
+		targets = []*target{nil}
+	}
+	var cmds []*buildCmd
+	for _, t := range targets {
+		for _, bn := range bins {
+			b, err := newBuildCmd(p, bn, t, prof, data)
+			if err != nil {
+				return nil, err
+			}
+			cmds = append(cmds, b)
+		}
+	}
+	return cmds, nil
+}
+
+func (b *buildCmd) args() []string {
+	args := append([]string{"go", "build"}, b.flags...)
+	args = append(args, "-o", b.output)
+	if b.files != nil {
+		return append(args, b.files...)
+	}
+	return append(args, b.pkg)
+}
+
+// pkgFiles returns the non-test .go files of dir
+func pkgFiles(dir string) ([]string, error) {
+	matches, err := filepath.Glob(toPath(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, m := range matches {
+		if !strings.HasSuffix(m, "_test.go") {
+			files = append(files, toPath(".", m))
+		}
+	}
+	if files == nil {
+		return nil, errors.New("no Go files in " + dir)
+	}
+	return files, nil
+}
+
+func (b *buildCmd) run() error {
+	return runCommandWithEnv("", b.env, b.args()...)
+}
+
+// String returns the command as it would be typed in a shell
+func (b *buildCmd) String() string {
+	var words []string
+	for _, w := range append(append([]string{}, b.env...), b.args()...) {
+		words = append(words, shellQuote(w))
+	}
+	return strings.Join(words, " ")
+}
+
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`*?[]{}()<>|&;#~") {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func build(p *gopkgCfg, bins []bin, targets []*target, prof *profile) error {
+	data := newStampData(p)
+	cmds, err := buildCmds(p, bins, targets, prof, data)
+	if err != nil {
+		return err
+	}
+	err = runHook(p, hookPreBuild)
+	if err != nil {
+		return err
+	}
+	err = runGenerators(p.Generate, false)
+	if err != nil {
+		return err
+	}
+	// fingerprint what is built, after hooks and generators changed it
+	sums, err := fingerprints(p, bins, targets, prof, data)
+	if err != nil {
+		return err
+	}
+	for i, b := range cmds {
+		if len(cmds) > 1 {
+			fmt.Println(greenText("Building"), b.output)
+		}
+		err = b.run()
+		if err != nil {
+			return fmt.Errorf("building %s failed: %v", b.output, err)
+		}
+		err = saveFingerprint(b.output, sums[i])
+		if err != nil {
+			return err
+		}
+	}
+	return runHook(p, hookPostBuild)
+}
+
+func buildCommand(args []string) error {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	targetName := fs.String("target", "", "cross-compile for os/arch")
+	allTargets := fs.Bool("all-targets", false, "cross-compile for all targets in gopkg.yaml")
+	profileName := fs.String("profile", "dev", "build profile")
+	printCommand := fs.Bool("print-command", false, "print the go build command and exit")
+	force := fs.Bool("force", false, "build even if the outputs are up to date")
+	repro := fs.Bool("reproducible", false, "build reproducibly")
+	verify := fs.Bool("verify-reproducible", false, "build twice and compare the outputs")
+	features := fs.String("features", "", "comma separated features to enable")
+	fs.Parse(args)
+	projectFeatures = parseFeatures(*features)
+
+	p, err := loadCfg(".")
+	if err != nil {
+		return err
+	}
+	err = resolveFeatures(p)
+	if err != nil {
+		return err
+	}
+	prof, err := findProfile(p, *profileName)
+	if err != nil {
+		return err
+	}
+	if *repro || *verify {
+		prof, err = reproducibleProfile(p, prof)
+		if err != nil {
+			return err
+		}
+	}
+	bins, err := selectBins(p, fs.Args())
+	if err != nil {
+		return err
+	}
+	var targets []*target
+	switch {
+	case *allTargets:
+		if len(p.Targets) == 0 {
+			return errors.New("gopkg.yaml: no targets declared")
+		}
+		for i := range p.Targets {
+			targets = append(targets, &p.Targets[i])
+		}
+	case *targetName != "":
+		t, err := findTarget(p, *targetName)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, t)
+	}
+
+	if *printCommand {
+		cmds, err := buildCmds(p, bins, targets, prof, newStampData(p))
+		if err != nil {
+			return err
+		}
+		for _, b := range cmds {
+			fmt.Println(b)
+		}
+		return nil
+	}
+	if *verify {
+		p, err = getDeps(".")
+		if err != nil {
+			return err
+		}
+		return verifyReproducible(p, bins, targets, prof)
+	}
+	if !*force {
+		fresh, err := upToDate(p, bins, targets, prof)
+		if err != nil {
+			return err
+		}
+		if fresh {
+			fmt.Println(greenText("Fresh"), "nothing changed since the last build")
+			return nil
+		}
+	}
+	p, err = getDeps(".")
+	if err != nil {
+		return err
+	}
+	return build(p, bins, targets, prof)
+}
+
+// pickBin returns the bin to run and its arguments. With a bins section
+// the first argument names the bin unless there is only one, arguments
+// after -- are passed to the program.
+func pickBin(p *gopkgCfg, args []string) (bin, []string, error) {
+	bins := p.binaries()
+	bn := bins[0]
+	if len(p.Bins) > 0 && len(args) > 0 && args[0] != "--" {
+		if found, err := selectBins(p, args[:1]); err == nil {
+			bn = found[0]
+			args = args[1:]
+		} else if len(bins) > 1 {
+			return bn, nil, err
+		}
+	} else if len(bins) > 1 {
+		return bn, nil, errors.New("more than one bin, usage: gopkg run <bin> -- [args]")
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	return bn, args, nil
+}
+
+// runBin builds and runs a bin, see pickBin for args. A leading -force
+// rebuilds even if nothing changed.
+func runBin(args []string) error {
+	// options before the bin name, everything else is the program's
+	force := false
+options:
+	for len(args) > 0 {
+		switch {
+		case args[0] == "-force" || args[0] == "--force":
+			force = true
+			args = args[1:]
+		case (args[0] == "-features" || args[0] == "--features") && len(args) > 1:
+			projectFeatures = parseFeatures(args[1])
+			args = args[2:]
+		case strings.HasPrefix(args[0], "-features=") || strings.HasPrefix(args[0], "--features="):
+			projectFeatures = parseFeatures(args[0][strings.IndexByte(args[0], '=')+1:])
+			args = args[1:]
+		default:
+			break options
+		}
+	}
+	p, err := loadCfg(".")
+	if err != nil {
+		return err
+	}
+	bn, args, err := pickBin(p, args)
+	if err != nil {
+		return err
+	}
+	err = resolveFeatures(p)
+	if err != nil {
+		return err
+	}
+	prof, err := findProfile(p, "dev")
+	if err != nil {
+		return err
+	}
+	fresh := false
+	if !force {
+		fresh, err = upToDate(p, []bin{bn}, nil, prof)
+		if err != nil {
+			return err
+		}
+	}
+	if fresh {
+		err = setProjectEnv(p)
+		if err != nil {
+			return err
+		}
+		err = setCgoEnv(p)
+		if err != nil {
+			return err
+		}
+	} else {
+		p, err = getDeps(".")
+		if err != nil {
+			return err
+		}
+		err = build(p, []bin{bn}, nil, prof)
+		if err != nil {
+			return err
+		}
+	}
+	// run the compiled program with given arguments
+	return runCommand(append([]string{toPath(".", bn.Name)}, args...)...)
+}
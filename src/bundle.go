@@ -0,0 +1,370 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"packages/yaml"
+)
+
+// bundleIndex is stored as index.yaml at the root of a bundle,
+// the sources of each package are under packages/<name>/
+type bundleIndex struct {
+	Packages []installedPkg `yaml:"packages"`
+}
+
+func bundleCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: gopkg bundle create|install <file.tar.gz>")
+	}
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("bundle create", flag.ExitOnError)
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return errors.New("usage: gopkg bundle create <file.tar.gz>")
+		}
+		return createBundle(fs.Arg(0))
+	case "install":
+		fs := flag.NewFlagSet("bundle install", flag.ExitOnError)
+		sum := fs.String("sha256", "", "expected SHA256 of the bundle file")
+		sumFile := fs.String("sha256-file", "", "file holding the expected SHA256, as written by bundle create")
+		unverified := fs.Bool("unverified", false, "install a bundle without a known SHA256")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return errors.New("usage: gopkg bundle install [-sha256 sum | -sha256-file file] [-unverified] <file.tar.gz>")
+		}
+		return installBundle(fs.Arg(0), *sum, *sumFile, *unverified)
+	}
+	return errors.New("unknown bundle command: " + args[0])
+}
+
+func createBundle(file string) error {
+	installed, err := loadInstalled()
+	if err != nil {
+		return err
+	}
+	pkgsDir := packagesDir
+	dirs, err := ioutil.ReadDir(pkgsDir)
+	if err != nil {
+		return err
+	}
+
+	gits, err := manifestGits()
+	if err != nil {
+		return err
+	}
+	var index bundleIndex
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		hash, err := hashDir(toPath(pkgsDir, d.Name()))
+		if err != nil {
+			return err
+		}
+		pkg := installedPkg{Name: d.Name(), Git: gits[d.Name()], Hash: hash}
+		if rec := findInstalled(installed, d.Name()); rec != nil {
+			pkg.Git = rec.Git
+			pkg.Commit = rec.Commit
+			if rec.Hash != hash {
+				fmt.Println(yellowText("Warning"), d.Name(), "has been modified since it was installed")
+			}
+		}
+		index.Packages = append(index.Packages, pkg)
+	}
+
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gw)
+
+	buf, err := yaml.Marshal(index)
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: "index.yaml", Mode: int64(filePerm), Size: int64(len(buf))})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	for _, pkg := range index.Packages {
+		fmt.Println(greenText("Bundling"), pkg.Name)
+		err = tarDir(tw, toPath(pkgsDir, pkg.Name), "packages/"+pkg.Name)
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	err = gw.Close()
+	if err != nil {
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+
+	// the index inside the bundle can be rewritten along with the files it
+	// lists, only a digest kept apart from it protects the bundle
+	sum, err := hashFile(file)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(file+".sha256", []byte(sum+"  "+filepath.Base(file)+"\n"), filePerm)
+	if err != nil {
+		return err
+	}
+	fmt.Println(greenText("Created"), file, "sha256:"+sum)
+	fmt.Println("  - Digest:", file+".sha256", "(send it separately and pass it to bundle install with -sha256-file)")
+	return nil
+}
+
+// manifestGits maps the packages declared in gopkg.yaml and in the
+// manifests of the installed packages to their git URLs
+func manifestGits() (map[string]string, error) {
+	gits := map[string]string{}
+	p, err := loadCfg(".")
+	if err != nil {
+		return nil, err
+	}
+	cfgs := []*gopkgCfg{p}
+	dirs, err := ioutil.ReadDir(packagesDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range dirs {
+		sub, err := depCfg(d.Name())
+		if err != nil {
+			return nil, err
+		}
+		if sub != nil {
+			cfgs = append(cfgs, sub)
+		}
+	}
+	for _, c := range cfgs {
+		for _, pkg := range c.Packages {
+			if pkg.Git != "" && gits[pkg.Name] == "" {
+				gits[pkg.Name] = pkg.Git
+			}
+		}
+	}
+	return gits, nil
+}
+
+// bundleSum returns the expected SHA256 of a bundle, from sum or from
+// sumFile. A digest lying next to the bundle is never picked up on its
+// own: whoever could replace the bundle could replace it too.
+func bundleSum(sum, sumFile string) (string, error) {
+	if sum != "" && sumFile != "" {
+		return "", errors.New("-sha256 and -sha256-file can't be used together")
+	}
+	if sum != "" {
+		return strings.TrimPrefix(sum, "sha256:"), nil
+	}
+	if sumFile == "" {
+		return "", nil
+	}
+	buf, err := ioutil.ReadFile(sumFile)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(buf))
+	if len(fields) == 0 {
+		return "", errors.New(sumFile + " is empty")
+	}
+	return strings.TrimPrefix(fields[0], "sha256:"), nil
+}
+
+// tarDir writes all files under dir into tw with names starting with prefix
+func tarDir(tw *tar.Writer, dir, prefix string) error {
+	return filepath.Walk(dir, func(p string, f os.FileInfo, err error) error {
+		if f == nil {
+			return err
+		}
+		if !f.IsDir() && !f.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(f, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if f.IsDir() {
+			hdr.Name += "/"
+		}
+		err = tw.WriteHeader(hdr)
+		if err != nil || f.IsDir() {
+			return err
+		}
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+}
+
+// untar extracts a .tar.gz file into dir, refusing entries outside of it
+func untar(file, dir string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return errors.New("bad file name in archive: " + hdr.Name)
+		}
+		dst := toPath(dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeDir:
+			err = os.MkdirAll(dst, dirPerm)
+		case tar.TypeReg:
+			err = os.MkdirAll(filepath.Dir(dst), dirPerm)
+			if err != nil {
+				return err
+			}
+			var out *os.File
+			out, err = os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+		default:
+			return errors.New("unsupported file type in archive: " + hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func installBundle(file, sum, sumFile string, unverified bool) error {
+	want, err := bundleSum(sum, sumFile)
+	if err != nil {
+		return err
+	}
+	got, err := hashFile(file)
+	if err != nil {
+		return err
+	}
+	switch {
+	case want == "" && !unverified:
+		return fmt.Errorf("%s: no digest given, pass the one printed by bundle create with -sha256 "+
+			"or -sha256-file, or pass -unverified to install anyway", file)
+	case want == "":
+		fmt.Println(yellowText("Warning"), file, "is not verified, anyone who could modify it "+
+			"could have changed the packages it contains, sha256:"+got)
+	case got != want:
+		return fmt.Errorf("%s: sha256 mismatch, expected %s, got %s", file, want, got)
+	}
+
+	tempDir := toPath(os.TempDir(), "gopkg-"+randomStr())
+	defer os.RemoveAll(tempDir)
+	err = untar(file, tempDir)
+	if err != nil {
+		return err
+	}
+	buf, err := ioutil.ReadFile(toPath(tempDir, "index.yaml"))
+	if err != nil {
+		return err
+	}
+	var index bundleIndex
+	err = yaml.Unmarshal(buf, &index)
+	if err != nil {
+		return err
+	}
+
+	// every directory in the bundle must be listed in the index
+	dirs, err := ioutil.ReadDir(toPath(tempDir, "packages"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, d := range dirs {
+		if findInstalled(index.Packages, d.Name()) == nil {
+			return errors.New("package not listed in bundle index: " + d.Name())
+		}
+	}
+
+	installed, err := loadInstalled()
+	if err != nil {
+		return err
+	}
+	// check everything before touching src/packages
+	for _, pkg := range index.Packages {
+		if pkg.Name == "" || strings.ContainsAny(pkg.Name, `/\`) || pkg.Name == "." || pkg.Name == ".." {
+			return errors.New("bad package name in bundle index: " + pkg.Name)
+		}
+		hash, err := hashDir(toPath(tempDir, "packages", pkg.Name))
+		if err != nil {
+			return err
+		}
+		if hash != pkg.Hash {
+			return fmt.Errorf("%s: hash mismatch, bundle says %s, got %s", pkg.Name, pkg.Hash, hash)
+		}
+		rec := findInstalled(installed, pkg.Name)
+		if rec != nil && rec.Commit == pkg.Commit && rec.Hash != pkg.Hash {
+			return fmt.Errorf("%s: hash %s does not match the recorded %s", pkg.Name, pkg.Hash, rec.Hash)
+		}
+	}
+
+	for _, pkg := range index.Packages {
+		fmt.Println(greenText("Installing"), pkg.Name, "["+pkg.Git+"]")
+		pkgPath := toPath(packagesDir, pkg.Name)
+		err = os.RemoveAll(pkgPath)
+		if err != nil {
+			return err
+		}
+		err = copyDir(toPath(tempDir, "packages", pkg.Name), pkgPath)
+		if err != nil {
+			return err
+		}
+		err = setInstalled(pkg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
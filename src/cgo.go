@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// cgoCfg configures cgo, pkg-config lists the system libraries needed
+type cgoCfg struct {
+	Enabled   *bool    `yaml:"enabled"`
+	Cflags    string   `yaml:"cflags"`
+	Ldflags   string   `yaml:"ldflags"`
+	PkgConfig []string `yaml:"pkg-config"`
+}
+
+// systemLibs maps the pkg-config packages needed by p and by the
+// dependencies walked with it to the names of the packages needing them
+func systemLibs(p *gopkgCfg) (map[string][]string, error) {
+	libs := map[string][]string{}
+	for _, lib := range p.Cgo.PkgConfig {
+		libs[lib] = append(libs[lib], p.Name)
+	}
+	names := make([]string, 0, len(visitedDeps))
+	for name := range visitedDeps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sub, err := depCfg(name)
+		if err != nil {
+			return nil, err
+		}
+		if sub == nil {
+			continue
+		}
+		for _, lib := range sub.Cgo.PkgConfig {
+			libs[lib] = append(libs[lib], name)
+		}
+	}
+	return libs, nil
+}
+
+func libNames(libs map[string][]string) []string {
+	names := make([]string, 0, len(libs))
+	for lib := range libs {
+		names = append(names, lib)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// joinFlags joins the non-empty flag strings with spaces
+func joinFlags(flags ...string) string {
+	var parts []string
+	for _, f := range flags {
+		if f = strings.TrimSpace(f); f != "" {
+			parts = append(parts, f)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// cgoFlagsBase holds the CGO_*FLAGS variables as they were before gopkg
+// set them
+var cgoFlagsBase = map[string]string{}
+
+// cgoFlags returns a CGO_*FLAGS variable with extra added, keeping go's
+// default of -g -O2 when the variable is not set
+func cgoFlags(key, extra string) string {
+	base, ok := cgoFlagsBase[key]
+	if !ok {
+		base, ok = os.LookupEnv(key)
+		if !ok {
+			base = "-g -O2"
+		}
+		cgoFlagsBase[key] = base
+	}
+	return key + "=" + joinFlags(base, extra)
+}
+
+// cgoEnv returns the CGO_* variables for p, the features must be resolved
+// first so that the dependencies are known
+func cgoEnv(p *gopkgCfg) ([]string, error) {
+	var env []string
+	if p.Cgo.Enabled != nil {
+		if *p.Cgo.Enabled {
+			env = append(env, "CGO_ENABLED=1")
+		} else {
+			env = append(env, "CGO_ENABLED=0")
+		}
+	}
+	libs, err := systemLibs(p)
+	if err != nil {
+		return nil, err
+	}
+	cflags, ldflags := p.Cgo.Cflags, p.Cgo.Ldflags
+	if len(libs) > 0 {
+		names := libNames(libs)
+		out, err := commandOutput("", append([]string{"pkg-config", "--cflags"}, names...)...)
+		if err != nil {
+			return nil, fmt.Errorf("pkg-config %s: %v, run gopkg check", strings.Join(names, " "), err)
+		}
+		cflags = joinFlags(cflags, out)
+		out, err = commandOutput("", append([]string{"pkg-config", "--libs"}, names...)...)
+		if err != nil {
+			return nil, fmt.Errorf("pkg-config %s: %v, run gopkg check", strings.Join(names, " "), err)
+		}
+		ldflags = joinFlags(ldflags, out)
+	}
+	if cflags != "" {
+		env = append(env, cgoFlags("CGO_CFLAGS", cflags))
+	}
+	if ldflags != "" {
+		env = append(env, cgoFlags("CGO_LDFLAGS", ldflags))
+	}
+	return env, nil
+}
+
+// setCgoEnv exports the cgo configuration of p to every command gopkg runs
+func setCgoEnv(p *gopkgCfg) error {
+	env, err := cgoEnv(p)
+	if err != nil {
+		return err
+	}
+	for _, kv := range env {
+		i := strings.IndexByte(kv, '=')
+		err = os.Setenv(kv[:i], kv[i+1:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missingLibs returns the system libraries in libs pkg-config can't find
+func missingLibs(libs map[string][]string) ([]string, error) {
+	_, err := exec.LookPath("pkg-config")
+	if err != nil {
+		return nil, errors.New("pkg-config not found, install it to check system libraries")
+	}
+	var missing []string
+	for _, lib := range libNames(libs) {
+		if exec.Command("pkg-config", "--exists", lib).Run() != nil {
+			missing = append(missing, lib)
+		}
+	}
+	return missing, nil
+}
+
+func checkCommand(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	features := fs.String("features", "", "comma separated features to enable")
+	fs.Parse(args)
+	projectFeatures = parseFeatures(*features)
+
+	p, err := loadCfg(".")
+	if err != nil {
+		return err
+	}
+	err = resolveFeatures(p)
+	if err != nil {
+		return err
+	}
+	libs, err := systemLibs(p)
+	if err != nil {
+		return err
+	}
+	if len(libs) == 0 {
+		fmt.Println("no system libraries needed")
+		return nil
+	}
+	missing, err := missingLibs(libs)
+	if err != nil {
+		return err
+	}
+	for _, lib := range libNames(libs) {
+		if contains(missing, lib) {
+			fmt.Println(yellowText("Missing"), lib, "needed by", strings.Join(libs[lib], ", "))
+		} else {
+			version, _ := commandOutput("", "pkg-config", "--modversion", lib)
+			fmt.Println(greenText("Found"), lib, version)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%d of %d system libraries missing, install their development packages or set PKG_CONFIG_PATH",
+			len(missing), len(libs))
+	}
+	return nil
+}
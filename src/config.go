@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"packages/yaml"
+)
+
+// userCfg is the per-user configuration, read from $GOPKG_HOME/config.yaml
+type userCfg struct {
+	Mirrors    []mirror `yaml:"mirrors"`
+	Registries []string `yaml:"registries"`
+}
+
+// gopkgHome returns the directory holding the user's gopkg files,
+// $GOPKG_HOME if set, otherwise ~/.gopkg
+func gopkgHome() string {
+	if home := os.Getenv("GOPKG_HOME"); home != "" {
+		return home
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".gopkg"
+	}
+	return toPath(home, ".gopkg")
+}
+
+// loadUserCfg reads the user configuration, a missing file is not an error
+func loadUserCfg() (*userCfg, error) {
+	var u = new(userCfg)
+	buf, err := ioutil.ReadFile(toPath(gopkgHome(), "config.yaml"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return u, nil
+		}
+		return nil, err
+	}
+	err = yaml.Unmarshal(buf, u)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// loadSources sets the mirrors and registries from the manifest p, which
+// may be nil outside a project, followed by those of the user
+// configuration. It must be called before anything is cloned.
+func loadSources(p *gopkgCfg) error {
+	u, err := loadUserCfg()
+	if err != nil {
+		return err
+	}
+	mirrors, registries = u.Mirrors, u.Registries
+	if p != nil {
+		mirrors = append(append([]mirror{}, p.Mirrors...), u.Mirrors...)
+		registries = append(append([]string{}, p.Registries...), u.Registries...)
+	}
+	return nil
+}
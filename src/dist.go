@@ -0,0 +1,287 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// distCfg is the dist section of gopkg.yaml
+type distCfg struct {
+	// files or glob patterns packed next to the binaries
+	Include []string `yaml:"include"`
+	// tar.gz or zip, by default zip for windows and tar.gz otherwise
+	Format string `yaml:"format"`
+}
+
+// distFile is a file to pack, Name is its path inside the archive
+type distFile struct {
+	Name string
+	Path string
+	Exec bool
+}
+
+// archiveTime is the mtime of every archive entry, SOURCE_DATE_EPOCH if
+// set so that archives are reproducible
+func archiveTime() (time.Time, error) {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		sec, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.New("invalid SOURCE_DATE_EPOCH: " + epoch)
+		}
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	// zip can not store times before 1980
+	return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC), nil
+}
+
+// includeFiles expands the include patterns, directories are walked
+func includeFiles(patterns []string) ([]distFile, error) {
+	var files []distFile
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, errors.New("dist.include: no file matches " + pattern)
+		}
+		for _, m := range matches {
+			err = filepath.Walk(m, func(p string, f os.FileInfo, err error) error {
+				if f == nil {
+					return err
+				}
+				if f.Mode().IsRegular() {
+					files = append(files, distFile{
+						Name: filepath.ToSlash(p),
+						Path: p,
+						Exec: f.Mode()&0111 != 0,
+					})
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+func sortDistFiles(files []distFile) {
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+}
+
+func distMode(f distFile) int64 {
+	if f.Exec {
+		return int64(execPerm)
+	}
+	return int64(filePerm)
+}
+
+// writeTarGz writes a .tar.gz with fixed times, ownership and ordering
+func writeTarGz(file, prefix string, files []distFile, mtime time.Time) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gw, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	// leave the gzip name and mtime empty
+	tw := tar.NewWriter(gw)
+
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     prefix + "/",
+		Mode:     int64(dirPerm),
+		ModTime:  mtime,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		buf, err := ioutil.ReadFile(f.Path)
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(prefix, f.Name),
+			Mode:     distMode(f),
+			Size:     int64(len(buf)),
+			ModTime:  mtime,
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	err = gw.Close()
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// writeZip writes a .zip with fixed times and ordering
+func writeZip(file, prefix string, files []distFile, mtime time.Time) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	for _, f := range files {
+		hdr := &zip.FileHeader{
+			Name:     path.Join(prefix, f.Name),
+			Method:   zip.Deflate,
+			Modified: mtime,
+		}
+		hdr.SetMode(os.FileMode(distMode(f)))
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(f.Path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// dist builds p for targets and packs each into dist/ with a SHA256SUMS
+func dist(p *gopkgCfg, targets []*target, prof *profile) error {
+	include, err := includeFiles(p.Dist.Include)
+	if err != nil {
+		return err
+	}
+	mtime, err := archiveTime()
+	if err != nil {
+		return err
+	}
+	bins := p.binaries()
+	err = build(p, bins, targets, prof)
+	if err != nil {
+		return err
+	}
+
+	var sums []string
+	for _, t := range targets {
+		prefix := p.Name
+		if p.Version != "" {
+			prefix += "-" + p.Version
+		}
+		prefix += "-" + t.OS + "-" + t.Arch
+
+		files := append([]distFile{}, include...)
+		for _, bn := range bins {
+			out := t.output(bn.Name)
+			files = append(files, distFile{Name: bn.Name + t.exeSuffix(), Path: out, Exec: true})
+		}
+		sortDistFiles(files)
+
+		format := p.Dist.Format
+		if format == "" {
+			format = "tar.gz"
+			if t.OS == "windows" {
+				format = "zip"
+			}
+		}
+		archive := toPath("dist", prefix+"."+format)
+		switch format {
+		case "tar.gz", "tgz":
+			err = writeTarGz(archive, prefix, files, mtime)
+		case "zip":
+			err = writeZip(archive, prefix, files, mtime)
+		default:
+			err = errors.New("dist.format: unknown format " + format)
+		}
+		if err != nil {
+			return err
+		}
+		sum, err := hashFile(archive)
+		if err != nil {
+			return err
+		}
+		sums = append(sums, sum+"  "+filepath.Base(archive))
+		fmt.Println(greenText("Packed"), archive)
+	}
+
+	// sort by file name
+	sort.Slice(sums, func(i, j int) bool { return sums[i][64:] < sums[j][64:] })
+	return ioutil.WriteFile(toPath("dist", "SHA256SUMS"), []byte(strings.Join(sums, "\n")+"\n"), filePerm)
+}
+
+func distCommand(args []string) error {
+	fs := flag.NewFlagSet("dist", flag.ExitOnError)
+	profileName := fs.String("profile", "release", "build profile")
+	features := fs.String("features", "", "comma separated features to enable")
+	fs.Parse(args)
+	projectFeatures = parseFeatures(*features)
+
+	p, err := loadCfg(".")
+	if err != nil {
+		return err
+	}
+	prof, err := findProfile(p, *profileName)
+	if err != nil {
+		return err
+	}
+	var targets []*target
+	for _, s := range fs.Args() {
+		t, err := findTarget(p, s)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, t)
+	}
+	if targets == nil {
+		for i := range p.Targets {
+			targets = append(targets, &p.Targets[i])
+		}
+	}
+	if targets == nil {
+		targets = append(targets, &target{OS: runtime.GOOS, Arch: runtime.GOARCH})
+	}
+
+	p, err = getDeps(".")
+	if err != nil {
+		return err
+	}
+	return dist(p, targets, prof)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// doctor collects the problems found by gopkg doctor
+type doctor struct {
+	problems int
+}
+
+func (d *doctor) ok(what ...interface{}) {
+	fmt.Println(append([]interface{}{greenText("OK")}, what...)...)
+}
+
+// problem reports what is wrong and how to fix it
+func (d *doctor) problem(what, fix string) {
+	d.problems++
+	fmt.Println(yellowText("Problem"), what)
+	fmt.Println("  fix:", fix)
+}
+
+func (d *doctor) checkTool(name string, version ...string) {
+	_, err := exec.LookPath(name)
+	if err != nil {
+		d.problem(name+" not found in PATH", "install "+name+" or add it to PATH")
+		return
+	}
+	out, err := commandOutput("", append([]string{name}, version...)...)
+	if err != nil {
+		d.problem(name+" does not run: "+err.Error(), "reinstall "+name)
+		return
+	}
+	d.ok(out)
+}
+
+// checkTempDirs looks for gopkg-* directories older than an hour, which
+// no running gopkg uses anymore
+func (d *doctor) checkTempDirs() {
+	dirs, err := filepath.Glob(toPath(os.TempDir(), "gopkg-*"))
+	if err != nil {
+		return
+	}
+	var stray []string
+	for _, dir := range dirs {
+		fi, err := os.Stat(dir)
+		if err == nil && fi.IsDir() && time.Since(fi.ModTime()) > time.Hour {
+			stray = append(stray, dir)
+		}
+	}
+	if len(stray) == 0 {
+		d.ok("no stray directories in", os.TempDir())
+		return
+	}
+	d.problem(fmt.Sprintf("%d stray directories in %s", len(stray), os.TempDir()),
+		"rm -rf "+strings.Join(stray, " "))
+}
+
+// checkCfg validates the manifest beyond what parsing it catches
+func (d *doctor) checkCfg(p *gopkgCfg) {
+	before := d.problems
+	if p.Name == "" {
+		d.problem("gopkg.yaml has no name", "add name: <project> to gopkg.yaml")
+	}
+	if p.Version != "" {
+		if _, err := parseVersion(p.Version); err != nil {
+			d.problem("gopkg.yaml: version "+p.Version+" is not semver", "use a version like 1.2.3")
+		}
+	}
+	if err := checkToolchain(p); err != nil {
+		d.problem(err.Error(), "install that go version or change go: in gopkg.yaml")
+	}
+	seen := map[string]bool{}
+	optional := map[string]bool{}
+	for _, pkg := range p.Packages {
+		switch {
+		case pkg.Name == "":
+			d.problem("gopkg.yaml: package without a name", "add name: to every entry of packages")
+			continue
+		case seen[pkg.Name]:
+			d.problem("gopkg.yaml: package "+pkg.Name+" is listed twice", "remove one of the entries")
+		case pkg.Git == "" && pkg.Version == "":
+			member := ""
+			if current != nil {
+				member, _ = current.member(pkg.Name)
+			}
+			if member == "" {
+				d.problem("gopkg.yaml: package "+pkg.Name+" has neither git nor version",
+					"add git: <url>, or version: <constraint> to look it up in a registry")
+			}
+		}
+		seen[pkg.Name] = true
+		if pkg.Optional {
+			optional[pkg.Name] = false
+		}
+	}
+	names := make([]string, 0, len(p.Features))
+	for name := range p.Features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, pkg := range p.Features[name].Packages {
+			if _, ok := optional[pkg]; ok {
+				optional[pkg] = true
+			} else {
+				d.problem("gopkg.yaml: feature "+name+" needs "+pkg+", which is not an optional package",
+					"add "+pkg+" to packages with optional: true")
+			}
+		}
+	}
+	unused := make([]string, 0, len(optional))
+	for pkg, used := range optional {
+		if !used {
+			unused = append(unused, pkg)
+		}
+	}
+	sort.Strings(unused)
+	for _, pkg := range unused {
+		d.problem("gopkg.yaml: optional package "+pkg+" is not used by any feature",
+			"list it in the packages of a feature or drop optional: true")
+	}
+	if d.problems == before {
+		d.ok("gopkg.yaml is valid")
+	}
+}
+
+// declaredDeps adds to names the packages declared by p and by the
+// installed packages it depends on, optional ones included
+func declaredDeps(p *gopkgCfg, names map[string]bool) error {
+	for _, pkg := range p.Packages {
+		if names[pkg.Name] {
+			continue
+		}
+		names[pkg.Name] = true
+		if !dirExists(toPath(packagesDir, pkg.Name)) {
+			continue
+		}
+		sub, err := depCfg(pkg.Name)
+		if err != nil {
+			return err
+		}
+		if sub != nil {
+			err = declaredDeps(sub, names)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkPackages looks for packages nobody declares and for packages
+// edited since they were installed
+func (d *doctor) checkPackages(p *gopkgCfg) error {
+	dirs, err := ioutil.ReadDir(packagesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	declared := map[string]bool{}
+	cfgs := []*gopkgCfg{p}
+	if current != nil {
+		// the packages directory is shared by all members
+		cfgs = nil
+		for _, dir := range current.memberDirs() {
+			m, err := loadCfg(dir)
+			if err != nil {
+				return err
+			}
+			cfgs = append(cfgs, m)
+		}
+	}
+	for _, c := range cfgs {
+		err = declaredDeps(c, declared)
+		if err != nil {
+			return err
+		}
+	}
+	installed, err := loadInstalled()
+	if err != nil {
+		return err
+	}
+	before := d.problems
+	for _, fi := range dirs {
+		name := fi.Name()
+		pkgPath := toPath(packagesDir, name)
+		if !declared[name] {
+			d.problem(pkgPath+" is not declared in any gopkg.yaml",
+				"rm -rf "+pkgPath+", or add "+name+" to packages in gopkg.yaml")
+			continue
+		}
+		rec := findInstalled(installed, name)
+		if rec == nil || fi.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		hash, err := hashDir(pkgPath)
+		if err != nil {
+			return err
+		}
+		if hash != rec.Hash {
+			d.problem(pkgPath+" was edited after it was installed",
+				"rm -rf "+pkgPath+" and run gopkg build to reinstall it, or move the changes upstream")
+		}
+	}
+	if d.problems == before {
+		d.ok("installed packages match gopkg.yaml")
+	}
+	return nil
+}
+
+func (d *doctor) checkSystemLibs(p *gopkgCfg) error {
+	err := resolveFeatures(p)
+	if err != nil {
+		d.problem(err.Error(), "fix the features section or the features requested of packages")
+		return nil
+	}
+	libs, err := systemLibs(p)
+	if err != nil {
+		return err
+	}
+	if len(libs) == 0 {
+		return nil
+	}
+	missing, err := missingLibs(libs)
+	if err != nil {
+		d.problem(err.Error(), "install pkg-config")
+		return nil
+	}
+	for _, lib := range missing {
+		d.problem("system library "+lib+" needed by "+strings.Join(libs[lib], ", ")+" not found",
+			"install the development package of "+lib+" or add its .pc directory to PKG_CONFIG_PATH")
+	}
+	if len(missing) == 0 {
+		d.ok("system libraries", strings.Join(libNames(libs), ", "))
+	}
+	return nil
+}
+
+func doctorCommand(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fs.Parse(args)
+
+	d := new(doctor)
+	d.checkTool("go", "version")
+	d.checkTool("git", "--version")
+	d.checkTempDirs()
+
+	if _, err := findProjectRoot("."); err != nil {
+		fmt.Println("not in a gopkg project, skipping project checks")
+	} else {
+		err = enterProject()
+		if err != nil {
+			return err
+		}
+		p, err := loadCfg(".")
+		if err != nil {
+			d.problem("gopkg.yaml does not parse: "+err.Error(), "fix the syntax error in gopkg.yaml")
+		} else {
+			d.checkCfg(p)
+			err = d.checkPackages(p)
+			if err != nil {
+				return err
+			}
+			err = d.checkSystemLibs(p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if d.problems > 0 {
+		return fmt.Errorf("%d problems found", d.problems)
+	}
+	fmt.Println("no problems found")
+	return nil
+}
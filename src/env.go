@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// findProjectRoot returns the nearest directory from dir upwards that
+// holds a gopkg.yaml
+func findProjectRoot(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if fileExists(toPath(dir, "gopkg.yaml")) {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("gopkg.yaml not found in the current directory or any parent, run gopkg new to create a project")
+		}
+		dir = parent
+	}
+}
+
+// enterProject changes to the project root above the current directory
+// and makes it the GOPATH, so that commands run from a subdirectory
+// operate on the whole project
+func enterProject() error {
+	root, err := findProjectRoot(".")
+	if err != nil {
+		return err
+	}
+	err = os.Chdir(root)
+	if err != nil {
+		return err
+	}
+	err = os.Setenv("GOPATH", root)
+	if err != nil {
+		return err
+	}
+	return useWorkspace(root)
+}
+
+// projectEnv returns the environment gopkg runs commands with in the
+// project entered with enterProject, as KEY=VALUE sorted by key
+func projectEnv(p *gopkgCfg) ([]string, error) {
+	vars := map[string]string{"GOPATH": os.Getenv("GOPATH")}
+	for k, v := range p.Env {
+		vars[k] = v
+	}
+	err := resolveFeatures(p)
+	if err != nil {
+		return nil, err
+	}
+	cgo, err := cgoEnv(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range cgo {
+		i := strings.IndexByte(kv, '=')
+		vars[kv[:i]] = kv[i+1:]
+	}
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		env = append(env, k+"="+vars[k])
+	}
+	return env, nil
+}
+
+func loadProjectEnv() ([]string, error) {
+	p, err := loadCfg(".")
+	if err != nil {
+		return nil, err
+	}
+	return projectEnv(p)
+}
+
+func envCommand(args []string) error {
+	fs := flag.NewFlagSet("env", flag.ExitOnError)
+	shell := fs.String("shell", "bash", "output format: bash, fish or json")
+	fs.Parse(args)
+
+	env, err := loadProjectEnv()
+	if err != nil {
+		return err
+	}
+	switch *shell {
+	case "bash", "sh", "zsh":
+		for _, kv := range env {
+			i := strings.IndexByte(kv, '=')
+			fmt.Println("export " + kv[:i] + "=" + shellQuote(kv[i+1:]))
+		}
+	case "fish":
+		for _, kv := range env {
+			i := strings.IndexByte(kv, '=')
+			fmt.Println("set -gx " + kv[:i] + " " + shellQuote(kv[i+1:]) + ";")
+		}
+	case "json":
+		vars := map[string]string{}
+		for _, kv := range env {
+			i := strings.IndexByte(kv, '=')
+			vars[kv[:i]] = kv[i+1:]
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(vars)
+	default:
+		return errors.New("unknown shell: " + *shell)
+	}
+	return nil
+}
+
+// execCommand runs args with the project environment and exits with the
+// command's exit code
+func execCommand(args []string) error {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return errors.New("usage: gopkg exec <command> [args]")
+	}
+	// the command runs where gopkg was started, not at the project root
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	err = enterProject()
+	if err != nil {
+		return err
+	}
+	env, err := loadProjectEnv()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = wd
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitCode(err))
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// feature is a named set of build tags and optional packages
+type feature struct {
+	Tags     []string `yaml:"tags"`
+	Packages []string `yaml:"packages"`
+}
+
+// projectFeatures are the features enabled with -features
+var projectFeatures []string
+
+// enabledFeatures holds the features requested of each package across
+// the dependency graph, "" being the project itself
+var enabledFeatures map[string][]string
+
+// visitedDeps are the packages already walked with their current features
+var visitedDeps map[string]bool
+
+// featureTags are the build tags of all enabled features
+var featureTags []string
+
+func resetFeatures() {
+	enabledFeatures = map[string][]string{"": projectFeatures}
+	visitedDeps = map[string]bool{}
+	featureTags = nil
+}
+
+func parseFeatures(s string) []string {
+	var features []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f != "" {
+			features = append(features, f)
+		}
+	}
+	return features
+}
+
+// tagsFlag returns the -tags flag of the enabled features for go test,
+// go build gets them with the tags of the profile and target
+func tagsFlag() []string {
+	if len(featureTags) == 0 {
+		return nil
+	}
+	return []string{"-tags", strings.Join(featureTags, ",")}
+}
+
+// enableFeatures adds features to those requested of name, it reports
+// whether any of them is new
+func enableFeatures(name string, features []string) bool {
+	changed := false
+	for _, f := range features {
+		if !contains(enabledFeatures[name], f) {
+			enabledFeatures[name] = append(enabledFeatures[name], f)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// wantedDeps returns the packages p needs with the features enabled for
+// it, and adds the build tags of those features
+func wantedDeps(p *gopkgCfg, name string) ([]dep, error) {
+	optional := map[string]bool{}
+	for _, f := range enabledFeatures[name] {
+		feat, ok := p.Features[f]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown feature %s", p.Name, f)
+		}
+		for _, tag := range feat.Tags {
+			if !contains(featureTags, tag) {
+				featureTags = append(featureTags, tag)
+			}
+		}
+		for _, pkg := range feat.Packages {
+			optional[pkg] = true
+		}
+	}
+	var deps []dep
+	for _, pkg := range p.Packages {
+		if pkg.Optional && !optional[pkg.Name] {
+			continue
+		}
+		deps = append(deps, pkg)
+	}
+	return deps, nil
+}
+
+// visitDep records the features requested of pkg, it reports whether
+// pkg has to be walked, that is the first time or with new features
+func visitDep(pkg dep) bool {
+	changed := enableFeatures(pkg.Name, pkg.Features)
+	if visitedDeps[pkg.Name] && !changed {
+		return false
+	}
+	visitedDeps[pkg.Name] = true
+	return true
+}
+
+// depCfg loads the manifest of an installed package, it returns nil if
+// the package has none
+func depCfg(name string) (*gopkgCfg, error) {
+	dir := toPath(packagesDir, name)
+	if current != nil {
+		member, err := current.member(name)
+		if err != nil {
+			return nil, err
+		}
+		if member != "" {
+			dir = member
+		}
+	}
+	if !fileExists(toPath(dir, "gopkg.yaml")) {
+		return nil, nil
+	}
+	return loadCfg(dir)
+}
+
+// resolveFeatures computes the enabled features and their build tags
+// from the packages already installed
+func resolveFeatures(p *gopkgCfg) error {
+	resetFeatures()
+	return walkInstalled(p, "")
+}
+
+func walkInstalled(p *gopkgCfg, name string) error {
+	deps, err := wantedDeps(p, name)
+	if err != nil {
+		return err
+	}
+	for _, pkg := range deps {
+		if !visitDep(pkg) || !dirExists(toPath(packagesDir, pkg.Name)) {
+			continue
+		}
+		sub, err := depCfg(pkg.Name)
+		if err != nil {
+			return err
+		}
+		if sub != nil {
+			err = walkInstalled(sub, pkg.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
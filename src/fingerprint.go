@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"packages/yaml"
+)
+
+// .gopkg/fingerprints.yaml maps each output to the fingerprint of the
+// sources, manifest and settings it was built from
+var fingerprintFile = toPath(".gopkg", "fingerprints.yaml")
+
+func loadFingerprints() (map[string]string, error) {
+	sums := map[string]string{}
+	buf, err := ioutil.ReadFile(fingerprintFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sums, nil
+		}
+		return nil, err
+	}
+	err = yaml.Unmarshal(buf, &sums)
+	return sums, err
+}
+
+func saveFingerprint(output, sum string) error {
+	sums, err := loadFingerprints()
+	if err != nil {
+		return err
+	}
+	sums[output] = sum
+	buf, err := yaml.Marshal(sums)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(".gopkg", dirPerm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fingerprintFile, buf, filePerm)
+}
+
+// fingerprints returns the fingerprint of each command of buildCmds.
+// The build time changes on every build and is left out.
+func fingerprints(p *gopkgCfg, bins []bin, targets []*target, prof *profile, data *stampData) ([]string, error) {
+	stable := *data
+	stable.BuildTime = ""
+	cmds, err := buildCmds(p, bins, targets, prof, &stable)
+	if err != nil {
+		return nil, err
+	}
+	srcSum, err := hashDir("src")
+	if err != nil {
+		return nil, err
+	}
+	cfgSum, err := hashFile("gopkg.yaml")
+	if err != nil {
+		return nil, err
+	}
+	if current != nil {
+		// the shared packages and the members linked there are outside src
+		pkgSum, err := hashPackages()
+		if err != nil {
+			return nil, err
+		}
+		srcSum += "\n" + pkgSum
+	}
+
+	cgo, err := cgoEnv(p)
+	if err != nil {
+		return nil, err
+	}
+	envs := map[string]string{}
+
+	var sums []string
+	for _, b := range cmds {
+		// the same environment go build gets: the cgo settings gopkg
+		// exports, then the target's
+		env := append(append([]string{}, cgo...), b.env...)
+		key := strings.Join(env, "\n")
+		if _, ok := envs[key]; !ok {
+			envs[key], err = goEnv(env)
+			if err != nil {
+				return nil, err
+			}
+		}
+		h := sha256.New()
+		io.WriteString(h, srcSum+"\n"+cfgSum+"\n"+envs[key]+"\n"+b.String()+"\n")
+		sums = append(sums, hex.EncodeToString(h.Sum(nil)))
+	}
+	return sums, nil
+}
+
+// goEnvVars are the settings of go env that change what go build produces
+var goEnvVars = []string{
+	"GOVERSION", "GOOS", "GOARCH", "CGO_ENABLED", "GOFLAGS",
+	"CGO_CFLAGS", "CGO_CPPFLAGS", "CGO_CXXFLAGS", "CGO_LDFLAGS",
+}
+
+// goEnv returns go env goEnvVars with env added to the environment, so
+// that a new toolchain or a different platform is not taken as fresh
+func goEnv(env []string) (string, error) {
+	cmd := exec.Command("go", append([]string{"env"}, goEnvVars...)...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("go env: %v", err)
+	}
+	return string(out), nil
+}
+
+// hashPackages hashes every package in packagesDir, following the
+// symlinks to workspace members
+func hashPackages() (string, error) {
+	dirs, err := ioutil.ReadDir(packagesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	h := sha256.New()
+	for _, d := range dirs {
+		dir, err := filepath.EvalSymlinks(toPath(packagesDir, d.Name()))
+		if err != nil {
+			return "", err
+		}
+		sum, err := hashDir(dir)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, sum+"  "+d.Name()+"\n")
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// upToDate reports whether every output exists and was built from the
+// current sources, manifest and settings, so that neither the dependency
+// walk nor go build is needed
+func upToDate(p *gopkgCfg, bins []bin, targets []*target, prof *profile) (bool, error) {
+	state, err := loadGenerateState()
+	if err != nil {
+		return false, err
+	}
+	for _, g := range p.Generate {
+		stale, err := g.stale(state)
+		if err != nil || stale {
+			return false, err
+		}
+	}
+	old, err := loadFingerprints()
+	if err != nil {
+		return false, err
+	}
+	if len(old) == 0 {
+		return false, nil
+	}
+	data := newStampData(p)
+	cmds, err := buildCmds(p, bins, targets, prof, data)
+	if err != nil {
+		return false, err
+	}
+	sums, err := fingerprints(p, bins, targets, prof, data)
+	if err != nil {
+		return false, err
+	}
+	for i, b := range cmds {
+		if !fileExists(b.output) || old[b.output] != sums[i] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
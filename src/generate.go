@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"packages/yaml"
+)
+
+// generator is an entry of the generate section. Inputs and outputs are
+// glob patterns relative to the project, directories match all their files.
+type generator struct {
+	Name    string   `yaml:"name"`
+	Command string   `yaml:"command"`
+	Inputs  []string `yaml:"inputs"`
+	Outputs []string `yaml:"outputs"`
+}
+
+// generateState is the hashes of the last run of a generator
+type generateState struct {
+	Inputs  string `yaml:"inputs"`
+	Outputs string `yaml:"outputs"`
+}
+
+var generateFile = toPath(".gopkg", "generate.yaml")
+
+func loadGenerateState() (map[string]generateState, error) {
+	state := map[string]generateState{}
+	buf, err := ioutil.ReadFile(generateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+	err = yaml.Unmarshal(buf, &state)
+	return state, err
+}
+
+func saveGenerateState(state map[string]generateState) error {
+	buf, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(".gopkg", dirPerm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(generateFile, buf, filePerm)
+}
+
+// hashGlobs hashes the files matched by patterns relative to dir, salt
+// is hashed first
+func hashGlobs(dir, salt string, patterns []string) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, salt+"\n")
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(toPath(dir, pattern))
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(matches)
+		io.WriteString(h, pattern+"\n")
+		for _, m := range matches {
+			var sum string
+			if dirExists(m) {
+				sum, err = hashDir(m)
+			} else {
+				sum, err = hashFile(m)
+			}
+			if err != nil {
+				return "", err
+			}
+			rel, err := filepath.Rel(dir, m)
+			if err != nil {
+				return "", err
+			}
+			io.WriteString(h, sum+"  "+filepath.ToSlash(rel)+"\n")
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (g *generator) hashes() (generateState, error) {
+	var s generateState
+	var err error
+	s.Inputs, err = hashGlobs(".", g.Command, g.Inputs)
+	if err != nil {
+		return s, err
+	}
+	s.Outputs, err = hashGlobs(".", "", g.Outputs)
+	return s, err
+}
+
+// stale reports whether the inputs of g or its outputs changed since
+// its last run
+func (g *generator) stale(state map[string]generateState) (bool, error) {
+	last, ok := state[g.Name]
+	if !ok {
+		return true, nil
+	}
+	now, err := g.hashes()
+	if err != nil {
+		return false, err
+	}
+	for _, pattern := range g.Outputs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return false, err
+		}
+		if len(matches) == 0 {
+			return true, nil
+		}
+	}
+	return now != last, nil
+}
+
+func selectGenerators(p *gopkgCfg, names []string) ([]generator, error) {
+	if len(names) == 0 {
+		return p.Generate, nil
+	}
+	var gens []generator
+	for _, name := range names {
+		found := false
+		for _, g := range p.Generate {
+			if g.Name == name {
+				gens = append(gens, g)
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("unknown generator: " + name)
+		}
+	}
+	return gens, nil
+}
+
+// runGenerators runs the generators whose inputs changed
+func runGenerators(gens []generator, force bool) error {
+	if len(gens) == 0 {
+		return nil
+	}
+	state, err := loadGenerateState()
+	if err != nil {
+		return err
+	}
+	for _, g := range gens {
+		stale, err := g.stale(state)
+		if err != nil {
+			return err
+		}
+		if !stale && !force {
+			continue
+		}
+		fmt.Println(greenText("Generating"), g.Name)
+		err = runCommand("sh", "-c", g.Command)
+		if err != nil {
+			return fmt.Errorf("generator %s failed: %v", g.Name, err)
+		}
+		state[g.Name], err = g.hashes()
+		if err != nil {
+			return err
+		}
+		err = saveGenerateState(state)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyProject copies the project to dst, leaving out .git and the local
+// state in .gopkg
+func copyProject(dst string) error {
+	return filepath.Walk(".", func(p string, f os.FileInfo, err error) error {
+		if f == nil {
+			return err
+		}
+		target := toPath(dst, p)
+		switch {
+		case f.IsDir() && (p == ".git" || p == ".gopkg"):
+			return filepath.SkipDir
+		case f.IsDir():
+			return os.MkdirAll(target, dirPerm)
+		case f.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !f.Mode().IsRegular():
+			return nil
+		}
+		_, err = copyFile(p, target)
+		if err != nil {
+			return err
+		}
+		return os.Chmod(target, f.Mode().Perm())
+	})
+}
+
+// checkGenerators runs the generators in a scratch copy of the project
+// and returns an error naming those whose outputs differ from the ones
+// in the project. It does not rely on .gopkg, which a fresh checkout
+// does not have.
+func checkGenerators(gens []generator) error {
+	tempDir := toPath(os.TempDir(), "gopkg-"+randomStr())
+	defer os.RemoveAll(tempDir)
+	err := copyProject(tempDir)
+	if err != nil {
+		return err
+	}
+	gopath := tempDir
+	if current != nil {
+		gopath = current.gopath(tempDir)
+	}
+	var stale []string
+	for _, g := range gens {
+		fmt.Println(greenText("Checking"), g.Name)
+		err = runCommandWithEnv(tempDir, []string{"GOPATH=" + gopath}, "sh", "-c", g.Command)
+		if err != nil {
+			return fmt.Errorf("generator %s failed: %v", g.Name, err)
+		}
+		want, err := hashGlobs(tempDir, "", g.Outputs)
+		if err != nil {
+			return err
+		}
+		got, err := hashGlobs(".", "", g.Outputs)
+		if err != nil {
+			return err
+		}
+		if got != want {
+			fmt.Println(yellowText("Stale"), g.Name)
+			stale = append(stale, g.Name)
+		}
+	}
+	if len(stale) > 0 {
+		return fmt.Errorf("generated files of %s are stale, run gopkg generate -force", strings.Join(stale, ", "))
+	}
+	return nil
+}
+
+func generateCommand(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	check := fs.Bool("check", false, "fail if running the generators would change the generated files")
+	force := fs.Bool("force", false, "run generators even if up to date")
+	fs.Parse(args)
+
+	p, err := loadCfg(".")
+	if err != nil {
+		return err
+	}
+	gens, err := selectGenerators(p, fs.Args())
+	if err != nil {
+		return err
+	}
+	err = setProjectEnv(p)
+	if err != nil {
+		return err
+	}
+	if *check {
+		return checkGenerators(gens)
+	}
+	return runGenerators(gens, *force)
+}
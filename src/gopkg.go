@@ -36,6 +36,7 @@ const (
 
 	filePerm os.FileMode = 0644 // -rw-r--r--
 	dirPerm  os.FileMode = 0755 // drwxr-xr-x
+	execPerm os.FileMode = 0755 // -rwxr-xr-x
 )
 
 func printHelp() {
@@ -46,7 +47,7 @@ func printHelp() {
 \    \_\  (  <_> )    |   |    |  \    \_\  \
  \______  /\____/|____|   |____|__ \______  /
         \/                        \/      \/`, "v"+version)
-	fmt.Println(`Usage:
+	fmt.Print(`Usage:
 
 	gopkg command [arguments]
 
@@ -54,10 +55,25 @@ The commands are:
 
 	new         create a new package
 	test        test packages
+	bench       run benchmarks and compare with a baseline
 	run         compile and run Go program
 	build       compile packages and dependencies
+	watch       rebuild, retest or rerun on changes
+	dist        build and pack release archives
+	install     build and install binaries into ~/.gopkg/bin
+	uninstall   remove installed binaries
+	run-script  run a script from gopkg.yaml
+	exec        run a command in the project environment
+	env         print the project environment
+	check       check that the system libraries cgo needs are installed
+	doctor      check the environment and the project for problems
+	generate    run code generators whose inputs changed
+	bundle      export or import dependencies for offline use
+	publish     register the current version in a registry
+	search      search packages in the registries
 
 Use "gopkg [command] -h" for more information about a command.
+
 `)
 }
 
@@ -114,8 +130,16 @@ func runCommand(command ...string) error {
 }
 
 func runCommandInDir(dir string, command ...string) error {
+	return runCommandWithEnv(dir, nil, command...)
+}
+
+// runCommandWithEnv runs command with env added to the environment
+func runCommandWithEnv(dir string, env []string, command ...string) error {
 	cmd := exec.Command(command[0], command[1:]...)
 	cmd.Dir = dir
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stdout
 
@@ -127,6 +151,29 @@ func runCommandInDir(dir string, command ...string) error {
 	return nil
 }
 
+func commandOutput(dir string, command ...string) (string, error) {
+	return runOutput(dir, os.Stderr, command...)
+}
+
+// quietOutput is commandOutput for probes whose failure is expected,
+// their error messages are thrown away
+func quietOutput(dir string, command ...string) (string, error) {
+	return runOutput(dir, nil, command...)
+}
+
+func runOutput(dir string, stderr io.Writer, command ...string) (string, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = dir
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
 func dirExists(path string) bool {
 	fi, err := os.Stat(path)
 	if err != nil {
@@ -187,15 +234,43 @@ func yellowText(s string) string {
 }
 
 type gopkgCfg struct {
-	Name     string   `yaml:"name"`
-	Authors  []string `yaml:"authors"`
-	Packages []struct {
-		Name   string `yaml:"name"`
-		Git    string `yaml:"git"`
-		Rev    string `yaml:"rev"`
-		Tag    string `yaml:"tag"`
-		Branch string `yaml:"branch"`
-	} `yaml:"packages"`
+	Name        string             `yaml:"name"`
+	Version     string             `yaml:"version"`
+	Description string             `yaml:"description"`
+	Keywords    []string           `yaml:"keywords"`
+	Go          string             `yaml:"go"`
+	Authors     []string           `yaml:"authors"`
+	Packages    []dep              `yaml:"packages"`
+	Mirrors     []mirror           `yaml:"mirrors"`
+	Registries  []string           `yaml:"registries"`
+	Targets     []target           `yaml:"targets"`
+	Profiles    map[string]profile `yaml:"profiles"`
+	Stamp       map[string]string  `yaml:"stamp"`
+	Bins        []bin              `yaml:"bins"`
+	Scripts     map[string]string  `yaml:"scripts"`
+	Env         map[string]string  `yaml:"env"`
+	Generate    []generator        `yaml:"generate"`
+	Dist        distCfg            `yaml:"dist"`
+	Bench       benchCfg           `yaml:"bench"`
+	Features    map[string]feature `yaml:"features"`
+	Cgo         cgoCfg             `yaml:"cgo"`
+}
+
+type dep struct {
+	Name   string `yaml:"name"`
+	Git    string `yaml:"git"`
+	Rev    string `yaml:"rev"`
+	Tag    string `yaml:"tag"`
+	Branch string `yaml:"branch"`
+	// version constraint, used to look up packages without git
+	Version string `yaml:"version"`
+	// only installed when an enabled feature lists it
+	Optional bool `yaml:"optional"`
+	// features of the package to enable
+	Features []string `yaml:"features"`
+
+	// checksum of src published in the registry
+	checksum string
 }
 
 func isSrcFile(fileName string) bool {
@@ -277,7 +352,7 @@ func conToGopkg(path string) error {
 	return nil
 }
 
-func getDeps(path string) (*gopkgCfg, error) {
+func loadCfg(path string) (*gopkgCfg, error) {
 	buf, err := ioutil.ReadFile(toPath(path, "gopkg.yaml"))
 	if err != nil {
 		return nil, err
@@ -287,40 +362,141 @@ func getDeps(path string) (*gopkgCfg, error) {
 	if err != nil {
 		return nil, err
 	}
+	return p, nil
+}
+
+func getDeps(path string) (*gopkgCfg, error) {
+	p, err := loadCfg(path)
+	if err != nil {
+		return nil, err
+	}
+	err = loadSources(p)
+	if err != nil {
+		return nil, err
+	}
+	err = setProjectEnv(p)
+	if err != nil {
+		return nil, err
+	}
 
 	tempDir := toPath(os.TempDir(), "gopkg-"+randomStr())
-	//defer os.RemoveAll(tempDir)
-	for _, pkg := range p.Packages {
-		pkgDir := toPath("src", "packages", pkg.Name)
+	resetFeatures()
+	fetched, err := fetchDeps(p, "", tempDir)
+	// removed before the hook, which exits gopkg when it fails
+	os.RemoveAll(tempDir)
+	if err != nil {
+		return nil, err
+	}
+	if fetched > 0 {
+		err = runHook(p, hookPostFetch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = setCgoEnv(p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// fetchDeps installs the missing packages of p, named name in the
+// dependency graph, and their dependencies, it returns how many were
+// installed
+func fetchDeps(p *gopkgCfg, name string, tempDir string) (int, error) {
+	deps, err := wantedDeps(p, name)
+	if err != nil {
+		return 0, err
+	}
+	fetched := 0
+	for _, pkg := range deps {
+		if !visitDep(pkg) {
+			continue
+		}
+		pkgDir := toPath(packagesDir, pkg.Name)
 		if dirExists(pkgDir) {
+			sub, err := depCfg(pkg.Name)
+			if err != nil {
+				return fetched, err
+			}
+			if sub != nil {
+				n, err := fetchDeps(sub, pkg.Name, tempDir)
+				fetched += n
+				if err != nil {
+					return fetched, err
+				}
+			}
 			continue
 		} else {
+			if pkg.Git == "" && current != nil {
+				dir, err := current.member(pkg.Name)
+				if err != nil {
+					return fetched, err
+				}
+				if dir != "" {
+					err = linkMember(pkg.Name, dir)
+					if err != nil {
+						return fetched, err
+					}
+					fetched++
+					sub, err := loadCfg(dir)
+					if err != nil {
+						return fetched, err
+					}
+					n, err := fetchDeps(sub, pkg.Name, tempDir)
+					fetched += n
+					if err != nil {
+						return fetched, err
+					}
+					continue
+				}
+			}
+			if pkg.Git == "" {
+				err := resolveDep(&pkg)
+				if err != nil {
+					return fetched, err
+				}
+			}
 			fmt.Println(greenText("Getting"), pkg.Name, "["+pkg.Git+"]")
+			if pkg.Version != "" {
+				fmt.Println("  - Version:", pkg.Version)
+			}
 
 			gitPath := toPath(tempDir, pkg.Name)
-			err := runCommand("git", "clone", "-q", pkg.Git, gitPath)
+			err := gitClone(pkg.Git, gitPath)
 			if err != nil {
-				os.Exit(1)
+				return fetched, fmt.Errorf("%s: %v", pkg.Name, err)
 			}
 			if pkg.Branch != "" {
 				fmt.Println("  - Branch:", pkg.Branch)
 				err = runCommandInDir(gitPath, "git", "checkout", "-q", pkg.Branch)
 				if err != nil {
-					os.Exit(1)
+					return fetched, fmt.Errorf("%s: checkout of branch %s failed: %v", pkg.Name, pkg.Branch, err)
 				}
 			}
 			if pkg.Tag != "" {
 				fmt.Println("  - Tag:", pkg.Tag)
 				err = runCommandInDir(gitPath, "git", "checkout", "-q", pkg.Tag)
 				if err != nil {
-					os.Exit(1)
+					return fetched, fmt.Errorf("%s: checkout of tag %s failed: %v", pkg.Name, pkg.Tag, err)
 				}
 			}
 			if pkg.Rev != "" {
 				fmt.Println("  - Rev:", pkg.Rev)
 				err = runCommandInDir(gitPath, "git", "reset", "-q", "--hard", pkg.Rev)
 				if err != nil {
-					os.Exit(1)
+					return fetched, fmt.Errorf("%s: reset to rev %s failed: %v", pkg.Name, pkg.Rev, err)
+				}
+			}
+
+			if pkg.checksum != "" {
+				sum, err := hashDir(toPath(gitPath, "src"))
+				if err != nil {
+					return fetched, err
+				}
+				if sum != pkg.checksum {
+					return fetched, fmt.Errorf("%s %s: checksum mismatch, registry says %s, got %s",
+						pkg.Name, pkg.Version, pkg.checksum, sum)
 				}
 			}
 
@@ -329,15 +505,15 @@ func getDeps(path string) (*gopkgCfg, error) {
 
 				err = conToGopkg(gitPath)
 				if err != nil {
-					return nil, err
+					return fetched, err
 				}
 			}
 
-			pkgPath := toPath("src", "packages", pkg.Name)
+			pkgPath := toPath(packagesDir, pkg.Name)
 			// 将 src 内源码移到 packages 目录中
 			err = copyDir(toPath(gitPath, "src"), pkgPath)
 			if err != nil {
-				return nil, err
+				return fetched, err
 			}
 			// 将剩余其他文件移到 packages 目录中（README、LICENSE等等）
 			err = filepath.Walk(gitPath, func(path string, f os.FileInfo, err error) error {
@@ -345,47 +521,77 @@ func getDeps(path string) (*gopkgCfg, error) {
 					return err
 				}
 				if f.IsDir() {
+					if path != gitPath && (f.Name() == ".git" || f.Name() == "src") {
+						return filepath.SkipDir
+					}
 					return nil
 				}
 				_, err = copyFile(path, toPath(pkgPath, f.Name()))
 				return err
 			})
 			if err != nil {
-				return nil, err
+				return fetched, err
 			}
 
 			// move ./src/packages/xxxx/packages to
 			// ./src/packages
 			pkgPkgPath := toPath(pkgPath, "packages")
-			copyDir(pkgPkgPath, toPath("src", "packages"))
+			copyDir(pkgPkgPath, packagesDir)
 			os.RemoveAll(pkgPkgPath)
 
+			err = recordInstalled(pkg, gitPath, pkgPath)
+			if err != nil {
+				return fetched, err
+			}
+
 			fmt.Println("  - " + greenText("Done") + "\n")
+			fetched++
 
-			_, err = getDeps(gitPath)
-			if err != nil {
-				return nil, err
+			if fileExists(toPath(gitPath, "gopkg.yaml")) {
+				sub, err := loadCfg(gitPath)
+				if err != nil {
+					return fetched, err
+				}
+				n, err := fetchDeps(sub, pkg.Name, tempDir)
+				fetched += n
+				if err != nil {
+					return fetched, err
+				}
 			}
 		}
 	}
-	return p, nil
+	return fetched, nil
 }
 
-func build(name string) {
-	err := runCommand("go", "build", "-o", name, toPath(".", "src"))
-	if err != nil {
-		os.Exit(1)
-	}
+// projectCommands operate on the project containing the current directory
+var projectCommands = map[string]bool{
+	"test":       true,
+	"watch":      true,
+	"bench":      true,
+	"run":        true,
+	"build":      true,
+	"dist":       true,
+	"generate":   true,
+	"env":        true,
+	"check":      true,
+	"run-script": true,
+	"bundle":     true,
+	"publish":    true,
 }
 
 func main() {
-	wd, err := os.Getwd()
-	if err != nil {
-		log.Fatal(err)
+	if (getArg(1) == "build" || getArg(1) == "test") && fileExists(workspaceFile) && os.Getenv(memberEnv) == "" {
+		err := fanOut(getArg(1), os.Args[2:])
+		if err != nil {
+			fatal(err)
+		}
+		return
 	}
-	err = os.Setenv("GOPATH", wd)
-	if err != nil {
-		log.Fatal(err)
+	if projectCommands[getArg(1)] {
+		err := enterProject()
+		if err != nil {
+			fatal(err)
+		}
 	}
 
 	switch getArg(1) {
@@ -395,30 +601,105 @@ func main() {
 		name := flag.Arg(0)
 		newPackage(name, *isLib)
 	case "test":
-		getDeps(".")
-		flag.CommandLine.Parse(os.Args[2:])
-		path := flag.Arg(0)
-		err := runCommand("go", "test", toPath(".", "src", path))
+		err := testCommand(os.Args[2:])
 		if err != nil {
-			os.Exit(1)
+			fatal(err)
 		}
-	case "run":
-		p, err := getDeps(".")
+	case "watch":
+		err := watch(os.Args[2:])
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
-		build(p.Name)
-		// run the compiled program with given arguments
-		err = runCommand(append([]string{toPath(".", p.Name)}, os.Args[2:]...)...)
+	case "bench":
+		err := benchCommand(os.Args[2:])
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
+		}
+	case "run":
+		err := runBin(os.Args[2:])
+		if err != nil {
+			fatal(err)
 		}
 	case "build":
-		p, err := getDeps(".")
+		err := buildCommand(os.Args[2:])
+		if err != nil {
+			fatal(err)
+		}
+	case "install":
+		err := installCommand(os.Args[2:])
+		if err != nil {
+			fatal(err)
+		}
+	case "uninstall":
+		err := uninstallCommand(os.Args[2:])
+		if err != nil {
+			fatal(err)
+		}
+	case "dist":
+		err := distCommand(os.Args[2:])
+		if err != nil {
+			fatal(err)
+		}
+	case "generate":
+		err := generateCommand(os.Args[2:])
+		if err != nil {
+			fatal(err)
+		}
+	case "env":
+		err := envCommand(os.Args[2:])
+		if err != nil {
+			fatal(err)
+		}
+	case "doctor":
+		err := doctorCommand(os.Args[2:])
+		if err != nil {
+			fatal(err)
+		}
+	case "check":
+		err := checkCommand(os.Args[2:])
+		if err != nil {
+			fatal(err)
+		}
+	case "exec":
+		err := execCommand(os.Args[2:])
+		if err != nil {
+			fatal(err)
+		}
+	case "run-script":
+		if getArg(2) == "" {
+			log.Fatal("usage: gopkg run-script <name> [args]")
+		}
+		p, err := loadCfg(".")
+		if err != nil {
+			fatal(err)
+		}
+		err = setProjectEnv(p)
+		if err != nil {
+			fatal(err)
+		}
+		err = runScript(p, getArg(2), os.Args[3:]...)
+		if err != nil {
+			fatal(err)
+		}
+	case "bundle":
+		err := bundleCommand(os.Args[2:])
+		if err != nil {
+			fatal(err)
+		}
+	case "publish":
+		registry := flag.String("registry", "", "registry to publish to")
+		flag.CommandLine.Parse(os.Args[2:])
+		err := publish(*registry)
+		if err != nil {
+			fatal(err)
+		}
+	case "search":
+		asJSON := flag.Bool("json", false, "print results as JSON")
+		flag.CommandLine.Parse(os.Args[2:])
+		err := search(strings.Join(flag.Args(), " "), *asJSON)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
-		build(p.Name)
 	default:
 		printHelp()
 	}
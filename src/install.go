@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"packages/yaml"
+)
+
+// installedTool records a project installed by gopkg install
+type installedTool struct {
+	Name    string   `yaml:"name"`
+	Version string   `yaml:"version,omitempty"`
+	Source  string   `yaml:"source"`
+	Commit  string   `yaml:"commit,omitempty"`
+	Bins    []string `yaml:"bins"`
+}
+
+func loadTools(root string) ([]installedTool, error) {
+	var tools []installedTool
+	buf, err := ioutil.ReadFile(toPath(root, "installed.yaml"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tools, nil
+		}
+		return nil, err
+	}
+	err = yaml.Unmarshal(buf, &tools)
+	return tools, err
+}
+
+func saveTools(root string, tools []installedTool) error {
+	buf, err := yaml.Marshal(tools)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(root, dirPerm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(toPath(root, "installed.yaml"), buf, filePerm)
+}
+
+// installProject builds the project in the working directory in release
+// mode and copies its binaries into root/bin
+func installProject(root, source string) error {
+	p, err := getDeps(".")
+	if err != nil {
+		return err
+	}
+	prof, err := findProfile(p, "release")
+	if err != nil {
+		return err
+	}
+	bins := p.binaries()
+	err = build(p, bins, nil, prof)
+	if err != nil {
+		return err
+	}
+
+	binDir := toPath(root, "bin")
+	err = os.MkdirAll(binDir, dirPerm)
+	if err != nil {
+		return err
+	}
+	tool := installedTool{Name: p.Name, Version: p.Version, Source: source}
+	tool.Commit, _ = commandOutput("", "git", "rev-parse", "HEAD")
+	for _, bn := range bins {
+		dst := toPath(binDir, bn.Name)
+		// write a new file so that a running binary is not modified
+		tmp := dst + ".tmp"
+		_, err = copyFile(bn.Name, tmp)
+		if err != nil {
+			return err
+		}
+		err = os.Chmod(tmp, execPerm)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(tmp, dst)
+		if err != nil {
+			return err
+		}
+		tool.Bins = append(tool.Bins, bn.Name)
+		fmt.Println(greenText("Installed"), dst)
+	}
+
+	tools, err := loadTools(root)
+	if err != nil {
+		return err
+	}
+	var kept []installedTool
+	for _, t := range tools {
+		if t.Name == tool.Name {
+			continue
+		}
+		// another project's binary was overwritten
+		var left []string
+		for _, b := range t.Bins {
+			if !contains(tool.Bins, b) {
+				left = append(left, b)
+			}
+		}
+		if len(left) > 0 {
+			t.Bins = left
+			kept = append(kept, t)
+		}
+	}
+	return saveTools(root, append(kept, tool))
+}
+
+// installRemote fetches a gopkg project into a temporary workspace and
+// installs it from there
+func installRemote(root, git string) error {
+	err := loadSources(nil)
+	if err != nil {
+		return err
+	}
+	tempDir := toPath(os.TempDir(), "gopkg-"+randomStr())
+	defer os.RemoveAll(tempDir)
+	fmt.Println(greenText("Getting"), "["+git+"]")
+	err = gitClone(git, tempDir)
+	if err != nil {
+		return err
+	}
+	if !fileExists(toPath(tempDir, "gopkg.yaml")) {
+		return errors.New(git + " is not a gopkg project")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		os.Chdir(wd)
+		os.Setenv("GOPATH", wd)
+	}()
+	err = os.Chdir(tempDir)
+	if err != nil {
+		return err
+	}
+	err = os.Setenv("GOPATH", tempDir)
+	if err != nil {
+		return err
+	}
+	return installProject(root, git)
+}
+
+func uninstall(root, name string) error {
+	tools, err := loadTools(root)
+	if err != nil {
+		return err
+	}
+	for i, t := range tools {
+		if t.Name != name {
+			continue
+		}
+		for _, b := range t.Bins {
+			err = os.Remove(toPath(root, "bin", b))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			fmt.Println(greenText("Removed"), toPath(root, "bin", b))
+		}
+		return saveTools(root, append(tools[:i], tools[i+1:]...))
+	}
+	return errors.New(name + " is not installed")
+}
+
+func listTools(root string) error {
+	tools, err := loadTools(root)
+	if err != nil {
+		return err
+	}
+	for _, t := range tools {
+		fmt.Println(greenText(t.Name), t.Version, "["+t.Source+"]")
+		for _, b := range t.Bins {
+			fmt.Println("  -", b)
+		}
+	}
+	return nil
+}
+
+func installCommand(args []string) error {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	root := fs.String("root", gopkgHome(), "install binaries into root/bin")
+	list := fs.Bool("list", false, "list installed projects")
+	fs.Parse(args)
+
+	if *list {
+		return listTools(*root)
+	}
+	// installRemote and enterProject change the directory
+	dir, err := filepath.Abs(*root)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return installRemote(dir, fs.Arg(0))
+	}
+	err = enterProject()
+	if err != nil {
+		return err
+	}
+	source, err := filepath.Abs(".")
+	if err != nil {
+		return err
+	}
+	if origin, err := commandOutput("", "git", "config", "--get", "remote.origin.url"); err == nil {
+		source = origin
+	}
+	return installProject(dir, source)
+}
+
+func uninstallCommand(args []string) error {
+	fs := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	root := fs.String("root", gopkgHome(), "remove binaries from root/bin")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: gopkg uninstall [-root dir] <name>")
+	}
+	return uninstall(*root, fs.Arg(0))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
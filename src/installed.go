@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"packages/yaml"
+)
+
+// installedPkg records where a package in src/packages came from
+type installedPkg struct {
+	Name    string `yaml:"name"`
+	Git     string `yaml:"git,omitempty"`
+	Version string `yaml:"version,omitempty"`
+	Commit  string `yaml:"commit,omitempty"`
+	Hash    string `yaml:"hash"`
+}
+
+var installedFile = toPath(".gopkg", "packages.yaml")
+
+func loadInstalled() ([]installedPkg, error) {
+	var pkgs []installedPkg
+	buf, err := ioutil.ReadFile(installedFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return pkgs, nil
+		}
+		return nil, err
+	}
+	err = yaml.Unmarshal(buf, &pkgs)
+	return pkgs, err
+}
+
+func saveInstalled(pkgs []installedPkg) error {
+	buf, err := yaml.Marshal(pkgs)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(installedFile), dirPerm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(installedFile, buf, filePerm)
+}
+
+// setInstalled adds or replaces the record of pkg.Name
+func setInstalled(pkg installedPkg) error {
+	pkgs, err := loadInstalled()
+	if err != nil {
+		return err
+	}
+	for i := range pkgs {
+		if pkgs[i].Name == pkg.Name {
+			pkgs[i] = pkg
+			return saveInstalled(pkgs)
+		}
+	}
+	return saveInstalled(append(pkgs, pkg))
+}
+
+func findInstalled(pkgs []installedPkg, name string) *installedPkg {
+	for i := range pkgs {
+		if pkgs[i].Name == name {
+			return &pkgs[i]
+		}
+	}
+	return nil
+}
+
+// recordInstalled saves the canonical git URL, the commit and the content
+// hash of a package just copied from gitPath to pkgPath
+func recordInstalled(pkg dep, gitPath, pkgPath string) error {
+	commit, err := commandOutput(gitPath, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	hash, err := hashDir(pkgPath)
+	if err != nil {
+		return err
+	}
+	return setInstalled(installedPkg{
+		Name:    pkg.Name,
+		Git:     pkg.Git,
+		Version: pkg.Version,
+		Commit:  commit,
+		Hash:    hash,
+	})
+}
+
+// hashDir returns a hash of the names and contents of all files under path
+func hashDir(path string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(path, func(p string, f os.FileInfo, err error) error {
+		if f == nil {
+			return err
+		}
+		if f.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		sum, err := hashFile(p)
+		if err != nil {
+			return err
+		}
+		io.WriteString(h, sum+"  "+filepath.ToSlash(rel)+"\n")
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// mirror rewrites git URLs starting with Prefix to start with Replace.
+// With Fallback set the original URL is tried when the mirror fails.
+type mirror struct {
+	Prefix   string `yaml:"prefix"`
+	Replace  string `yaml:"replace"`
+	Fallback bool   `yaml:"fallback"`
+}
+
+// mirrors holds the rules of the project followed by the rules of the user,
+// they are applied to every git fetch, transitive ones included
+var mirrors []mirror
+
+// mirrorURLs returns the URLs to try in order when fetching git
+func mirrorURLs(git string) []string {
+	for _, m := range mirrors {
+		if m.Prefix == "" || !strings.HasPrefix(git, m.Prefix) {
+			continue
+		}
+		url := m.Replace + git[len(m.Prefix):]
+		if m.Fallback {
+			return []string{url, git}
+		}
+		return []string{url}
+	}
+	return []string{git}
+}
+
+// gitClone clones git into dir through the configured mirrors.
+// The clone's origin always points at the canonical URL.
+func gitClone(git, dir string) error {
+	var err error
+	for _, url := range mirrorURLs(git) {
+		if url != git {
+			fmt.Println("  - Mirror:", url)
+		} else if err != nil {
+			fmt.Println("  - " + yellowText("Mirror failed") + ", trying " + git)
+		}
+		err = runCommand("git", "clone", "-q", url, dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			continue
+		}
+		if url != git {
+			return runCommandInDir(dir, "git", "remote", "set-url", "origin", git)
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to clone %s: %v", git, err)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"packages/yaml"
+)
+
+// publish checks the working tree, builds and tests it and then appends
+// the current version to a registry index
+func publish(registry string) error {
+	p, err := loadCfg(".")
+	if err != nil {
+		return err
+	}
+	if p.Name == "" {
+		return errors.New("gopkg.yaml: name is not set")
+	}
+	if p.Version == "" {
+		return errors.New("gopkg.yaml: version is not set")
+	}
+	v, err := parseVersion(p.Version)
+	if err != nil {
+		return err
+	}
+
+	status, err := commandOutput("", "git", "status", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if status != "" {
+		return errors.New("working tree is not clean:\n" + status)
+	}
+	tags, err := commandOutput("", "git", "tag", "--points-at", "HEAD")
+	if err != nil {
+		return err
+	}
+	tag := ""
+	for _, t := range strings.Fields(tags) {
+		if t == p.Version || t == "v"+p.Version {
+			tag = t
+		}
+	}
+	if tag == "" {
+		return fmt.Errorf("HEAD is not tagged v%s", p.Version)
+	}
+	commit, err := commandOutput("", "git", "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	source, err := commandOutput("", "git", "config", "--get", "remote.origin.url")
+	if err != nil {
+		return errors.New("no source URL, git remote origin is not set")
+	}
+
+	err = loadSources(p)
+	if err != nil {
+		return err
+	}
+	if registry == "" {
+		if len(registries) == 0 {
+			return errors.New("no registry configured")
+		}
+		registry = registries[0]
+	}
+	dir, err := registryDir(registry)
+	if err != nil {
+		return err
+	}
+	file := toPath(dir, p.Name+".yaml")
+	var rp = &registryPkg{Name: p.Name, Git: source}
+	if fileExists(file) {
+		rp, err = loadRegistryPkg(file)
+		if err != nil {
+			return err
+		}
+		if rp.Git != source {
+			return fmt.Errorf("%s is already registered from %s", p.Name, rp.Git)
+		}
+		for _, rv := range rp.Versions {
+			old, err := parseVersion(rv.Version)
+			if err == nil && old.compare(v) == 0 {
+				return fmt.Errorf("%s %s is already published", p.Name, rv.Version)
+			}
+		}
+	}
+
+	_, err = getDeps(".")
+	if err != nil {
+		return err
+	}
+	prof, err := findProfile(p, "dev")
+	if err != nil {
+		return err
+	}
+	err = build(p, p.binaries(), nil, prof)
+	if err != nil {
+		return err
+	}
+	err = runHook(p, hookPreTest)
+	if err != nil {
+		return err
+	}
+	dirs, err := testPackages(nil, false)
+	if err != nil {
+		return err
+	}
+	results, err := runTests(dirs, &testOpts{})
+	if err != nil {
+		return err
+	}
+	if failed := printTestSummary(results, false); failed > 0 {
+		return errors.New("tests failed")
+	}
+
+	checksum, err := srcChecksum()
+	if err != nil {
+		return err
+	}
+
+	rp.Description = p.Description
+	rp.Keywords = p.Keywords
+	rp.Authors = p.Authors
+	rp.Versions = append(rp.Versions, registryVersion{
+		Version:  p.Version,
+		Tag:      tag,
+		Commit:   commit,
+		Checksum: checksum,
+	})
+	buf, err := yaml.Marshal(rp)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(file, buf, filePerm)
+	if err != nil {
+		return err
+	}
+	if dir != registry {
+		msg := "Publish " + p.Name + " " + p.Version
+		for _, cmd := range [][]string{
+			{"git", "add", p.Name + ".yaml"},
+			{"git", "commit", "-q", "-m", msg},
+			{"git", "push", "-q"},
+		} {
+			err = runCommandInDir(dir, cmd...)
+			if err != nil {
+				return fmt.Errorf("failed to update registry %s: %v", registry, err)
+			}
+		}
+	}
+
+	fmt.Println(greenText("Published"), p.Name, p.Version, "["+source+"]")
+	return nil
+}
+
+// srcChecksum hashes src as committed at HEAD, the same content
+// getDeps sees after checking out the published commit
+func srcChecksum() (string, error) {
+	tempDir := toPath(os.TempDir(), "gopkg-"+randomStr())
+	defer os.RemoveAll(tempDir)
+	err := os.MkdirAll(tempDir, dirPerm)
+	if err != nil {
+		return "", err
+	}
+	archive := toPath(tempDir, "src.tar.gz")
+	err = runCommand("git", "archive", "--format=tar.gz", "-o", archive, "HEAD", "src")
+	if err != nil {
+		return "", err
+	}
+	err = untar(archive, toPath(tempDir, "tree"))
+	if err != nil {
+		return "", err
+	}
+	return hashDir(toPath(tempDir, "tree", "src"))
+}
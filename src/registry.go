@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"packages/yaml"
+)
+
+// registryPkg is one <name>.yaml file of a registry index. An index is a
+// local directory or a git repository holding such files.
+type registryPkg struct {
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description,omitempty"`
+	Keywords    []string          `yaml:"keywords,omitempty"`
+	Authors     []string          `yaml:"authors,omitempty"`
+	Git         string            `yaml:"git"`
+	Versions    []registryVersion `yaml:"versions"`
+}
+
+type registryVersion struct {
+	Version  string `yaml:"version"`
+	Tag      string `yaml:"tag,omitempty"`
+	Commit   string `yaml:"commit,omitempty"`
+	Checksum string `yaml:"checksum,omitempty"`
+}
+
+// registries holds the indexes of the project followed by the user's
+var registries []string
+
+// synced git registries of this run
+var syncedRegistries = map[string]string{}
+
+// registryDir returns a local directory holding the index reg,
+// git indexes are cloned or updated under $GOPKG_HOME/registries
+func registryDir(reg string) (string, error) {
+	if dirExists(reg) {
+		return reg, nil
+	}
+	if dir, ok := syncedRegistries[reg]; ok {
+		return dir, nil
+	}
+	sum := sha256.Sum256([]byte(reg))
+	dir := toPath(gopkgHome(), "registries", hex.EncodeToString(sum[:8]))
+	if dirExists(dir) {
+		// keep stdout clean for gopkg search -json
+		fmt.Fprintln(os.Stderr, greenText("Updating"), "registry", reg)
+		err := runCommandInDir(dir, "git", "pull", "-q", "--ff-only")
+		if err != nil {
+			return "", fmt.Errorf("failed to update registry %s: %v", reg, err)
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, greenText("Getting"), "registry", reg)
+		err := os.MkdirAll(toPath(gopkgHome(), "registries"), dirPerm)
+		if err != nil {
+			return "", err
+		}
+		err = gitClone(reg, dir)
+		if err != nil {
+			return "", err
+		}
+	}
+	syncedRegistries[reg] = dir
+	return dir, nil
+}
+
+func loadRegistryPkg(file string) (*registryPkg, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var pkg = new(registryPkg)
+	err = yaml.Unmarshal(buf, pkg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return pkg, nil
+}
+
+// lookupPackage returns the first entry called name in the registries
+func lookupPackage(name string) (*registryPkg, error) {
+	if len(registries) == 0 {
+		return nil, errors.New(name + ": no git URL given and no registry configured")
+	}
+	for _, reg := range registries {
+		dir, err := registryDir(reg)
+		if err != nil {
+			return nil, err
+		}
+		file := toPath(dir, name+".yaml")
+		if !fileExists(file) {
+			continue
+		}
+		return loadRegistryPkg(file)
+	}
+	return nil, errors.New(name + ": not found in any registry")
+}
+
+// latestVersion returns the highest version matching constraint
+func (pkg *registryPkg) latestVersion(constraint string) (*registryVersion, error) {
+	var best *registryVersion
+	var bestV semver
+	for i, rv := range pkg.Versions {
+		v, err := parseVersion(rv.Version)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pkg.Name, err)
+		}
+		ok, err := matchVersion(v, constraint)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pkg.Name, err)
+		}
+		if ok && (best == nil || v.compare(bestV) > 0) {
+			best, bestV = &pkg.Versions[i], v
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%s: no version matching %q", pkg.Name, constraint)
+	}
+	return best, nil
+}
+
+// resolveDep fills the source of a dependency given only by name and version
+func resolveDep(pkg *dep) error {
+	rp, err := lookupPackage(pkg.Name)
+	if err != nil {
+		return err
+	}
+	rv, err := rp.latestVersion(pkg.Version)
+	if err != nil {
+		return err
+	}
+	pkg.Git = rp.Git
+	pkg.Version = rv.Version
+	pkg.checksum = rv.Checksum
+	switch {
+	case rv.Commit != "":
+		pkg.Rev = rv.Commit
+	case rv.Tag != "":
+		pkg.Tag = rv.Tag
+	default:
+		pkg.Tag = "v" + strings.TrimPrefix(rv.Version, "v")
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestLatestVersion(t *testing.T) {
+	pkg := &registryPkg{Name: "lib"}
+	for _, v := range []string{"0.9.0", "1.0.0", "1.2.0", "1.10.0", "2.0.0-rc.1", "1.11.0-beta.2", "1.11.0-beta.10"} {
+		pkg.Versions = append(pkg.Versions, registryVersion{Version: v})
+	}
+	tests := []struct {
+		constraint string
+		want       string
+	}{
+		{"", "1.10.0"},
+		{"*", "1.10.0"},
+		{"^1", "1.10.0"},
+		{"~1.2", "1.2.0"},
+		{"<1", "0.9.0"},
+		{"^0.9", "0.9.0"},
+		{"2.0.0-rc.1", "2.0.0-rc.1"},
+		{"1.11.0-beta.10", "1.11.0-beta.10"},
+	}
+	for _, tt := range tests {
+		rv, err := pkg.latestVersion(tt.constraint)
+		if err != nil {
+			t.Errorf("latestVersion(%q): %v", tt.constraint, err)
+			continue
+		}
+		if rv.Version != tt.want {
+			t.Errorf("latestVersion(%q) = %s, want %s", tt.constraint, rv.Version, tt.want)
+		}
+	}
+
+	if _, err := pkg.latestVersion("^3"); err == nil {
+		t.Errorf("latestVersion(^3) must fail")
+	}
+	pkg.Versions = append(pkg.Versions, registryVersion{Version: "bad"})
+	if _, err := pkg.latestVersion(""); err == nil {
+		t.Errorf("latestVersion with an invalid version in the index must fail")
+	}
+}
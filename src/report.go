@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// reportFlags collects -report format=file flags
+type reportFlags []string
+
+func (r *reportFlags) String() string { return strings.Join(*r, ",") }
+
+func (r *reportFlags) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 || i == len(s)-1 {
+		return errors.New("want format=file")
+	}
+	switch s[:i] {
+	case "junit", "json":
+	default:
+		return errors.New("unknown report format " + s[:i] + ", want junit or json")
+	}
+	*r = append(*r, s)
+	return nil
+}
+
+// testEvent is a line of go test -json output
+type testEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+type testCase struct {
+	Name    string   `json:"name"`
+	Status  string   `json:"status"`
+	Elapsed float64  `json:"elapsed"`
+	Output  []string `json:"output,omitempty"`
+}
+
+type pkgReport struct {
+	Dir     string      `json:"dir"`
+	Package string      `json:"package"`
+	Status  string      `json:"status"`
+	Elapsed float64     `json:"elapsed"`
+	Tests   []*testCase `json:"tests"`
+	// output not belonging to a test, like build errors
+	Output []string `json:"output,omitempty"`
+}
+
+func (r *pkgReport) count(status string) int {
+	n := 0
+	for _, t := range r.Tests {
+		if t.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// runTestJSON runs go test -json, printing the test output as it comes
+func runTestJSON(dir string, args []string) (*pkgReport, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stdout
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &pkgReport{Dir: dir}
+	tests := map[string]*testCase{}
+	s := bufio.NewScanner(stdout)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		var e testEvent
+		if json.Unmarshal(s.Bytes(), &e) != nil {
+			// not an event, build output of go test itself
+			fmt.Println(s.Text())
+			r.Output = append(r.Output, s.Text()+"\n")
+			continue
+		}
+		if e.Package != "" {
+			r.Package = e.Package
+		}
+		if e.Test == "" {
+			switch e.Action {
+			case "output", "build-output":
+				fmt.Print(e.Output)
+				r.Output = append(r.Output, e.Output)
+			case "pass", "fail", "skip":
+				r.Status = e.Action
+				r.Elapsed = e.Elapsed
+			}
+			continue
+		}
+		t := tests[e.Test]
+		if t == nil {
+			t = &testCase{Name: e.Test}
+			tests[e.Test] = t
+			r.Tests = append(r.Tests, t)
+		}
+		switch e.Action {
+		case "output":
+			fmt.Print(e.Output)
+			t.Output = append(t.Output, e.Output)
+		case "pass", "fail", "skip":
+			t.Status = e.Action
+			t.Elapsed = e.Elapsed
+		}
+	}
+	err = cmd.Wait()
+	if r.Status == "" || err != nil && r.Status == "pass" {
+		r.Status = "fail"
+	}
+	return r, s.Err()
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr,omitempty"`
+	Cases     []junitCase `xml:"testcase"`
+	SystemOut string      `xml:"system-out,omitempty"`
+}
+
+type junitCase struct {
+	Classname string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
+
+// failureMessage returns the first line a test logged, usually the
+// file:line of the failed check
+func failureMessage(output []string) string {
+	for _, line := range output {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "=== ") && !strings.HasPrefix(line, "--- ") {
+			return line
+		}
+	}
+	return "failed"
+}
+
+func writeJUnit(file string, reports []*pkgReport) error {
+	var all junitSuites
+	var total float64
+	for _, r := range reports {
+		suite := junitSuite{Name: r.Dir, Time: seconds(r.Elapsed)}
+		for _, t := range r.Tests {
+			c := junitCase{Classname: r.Dir, Name: t.Name, Time: seconds(t.Elapsed)}
+			output := strings.Join(t.Output, "")
+			switch t.Status {
+			case "fail":
+				c.Failure = &junitMessage{Message: failureMessage(t.Output), Text: output}
+				suite.Failures++
+			case "skip":
+				c.Skipped = &junitMessage{Message: failureMessage(t.Output)}
+				c.SystemOut = output
+				suite.Skipped++
+			default:
+				c.SystemOut = output
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+		// a package failing without a failed test did not build
+		if r.Status == "fail" && suite.Failures == 0 {
+			output := strings.Join(r.Output, "")
+			suite.Cases = append(suite.Cases, junitCase{
+				Classname: r.Dir,
+				Name:      "build",
+				Time:      seconds(0),
+				Failure:   &junitMessage{Message: failureMessage(r.Output), Text: output},
+			})
+			suite.Failures++
+		} else {
+			suite.SystemOut = strings.Join(r.Output, "")
+		}
+		suite.Tests = len(suite.Cases)
+		all.Tests += suite.Tests
+		all.Failures += suite.Failures
+		all.Skipped += suite.Skipped
+		total += r.Elapsed
+		all.Suites = append(all.Suites, suite)
+	}
+	all.Time = seconds(total)
+
+	buf, err := xml.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	buf = append([]byte(xml.Header), buf...)
+	return ioutil.WriteFile(file, append(buf, '\n'), filePerm)
+}
+
+type jsonSummary struct {
+	Packages int          `json:"packages"`
+	Failed   int          `json:"failed_packages"`
+	Tests    int          `json:"tests"`
+	Passed   int          `json:"passed"`
+	Failures int          `json:"failures"`
+	Skipped  int          `json:"skipped"`
+	Elapsed  float64      `json:"elapsed"`
+	Results  []*pkgReport `json:"results"`
+}
+
+func writeJSONReport(file string, reports []*pkgReport) error {
+	sum := jsonSummary{Packages: len(reports), Results: reports}
+	for _, r := range reports {
+		if r.Status == "fail" {
+			sum.Failed++
+		}
+		sum.Tests += len(r.Tests)
+		sum.Passed += r.count("pass")
+		sum.Failures += r.count("fail")
+		sum.Skipped += r.count("skip")
+		sum.Elapsed += r.Elapsed
+	}
+	buf, err := json.MarshalIndent(sum, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(buf, '\n'), filePerm)
+}
+
+// writeReports writes each -report format=file
+func writeReports(reports []string, results []testResult) error {
+	var pkgs []*pkgReport
+	for _, r := range results {
+		if r.report != nil {
+			pkgs = append(pkgs, r.report)
+		}
+	}
+	for _, rep := range reports {
+		i := strings.IndexByte(rep, '=')
+		format, file := rep[:i], rep[i+1:]
+		var err error
+		switch format {
+		case "junit":
+			err = writeJUnit(file, pkgs)
+		case "json":
+			err = writeJSONReport(file, pkgs)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println(greenText("Report"), file)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// reproducible is set by build -reproducible, the build time is then
+// taken from SOURCE_DATE_EPOCH only
+var reproducible bool
+
+// checkToolchain fails if the go in PATH is not the one named by the
+// go field of gopkg.yaml, go: 1.21 accepts any go1.21.x
+func checkToolchain(p *gopkgCfg) error {
+	if p.Go == "" {
+		return nil
+	}
+	installed, err := commandOutput("", "go", "env", "GOVERSION")
+	if err != nil {
+		return err
+	}
+	want := "go" + strings.TrimPrefix(p.Go, "go")
+	if installed != want && !strings.HasPrefix(installed, want+".") {
+		return fmt.Errorf("gopkg.yaml wants %s, but %s is installed", want, installed)
+	}
+	return nil
+}
+
+// reproducibleProfile returns prof with the settings a reproducible
+// build needs: -trimpath and an empty build id
+func reproducibleProfile(p *gopkgCfg, prof *profile) (*profile, error) {
+	err := checkToolchain(p)
+	if err != nil {
+		return nil, err
+	}
+	reproducible = true
+	rp := *prof
+	rp.Trimpath = true
+	rp.Ldflags = strings.TrimSpace(rp.Ldflags + " -buildid=")
+	return &rp, nil
+}
+
+// verifyReproducible builds twice, each time in a copy of the project
+// in its own temporary GOPATH, and compares the outputs
+func verifyReproducible(p *gopkgCfg, bins []bin, targets []*target, prof *profile) error {
+	err := runHook(p, hookPreBuild)
+	if err != nil {
+		return err
+	}
+	err = runGenerators(p.Generate, false)
+	if err != nil {
+		return err
+	}
+	cmds, err := buildCmds(p, bins, targets, prof, newStampData(p))
+	if err != nil {
+		return err
+	}
+
+	var sums [2][]string
+	for i := range sums {
+		tempDir := toPath(os.TempDir(), "gopkg-"+randomStr())
+		defer os.RemoveAll(tempDir)
+		err = copyDir("src", toPath(tempDir, "src"))
+		if err != nil {
+			return err
+		}
+		_, err = copyFile("gopkg.yaml", toPath(tempDir, "gopkg.yaml"))
+		if err != nil {
+			return err
+		}
+		gopath := tempDir
+		if current != nil {
+			gopath = current.gopath(tempDir)
+		}
+		fmt.Println(greenText("Building"), "in", tempDir)
+		for _, b := range cmds {
+			err = runCommandWithEnv(tempDir, append(b.env, "GOPATH="+gopath), b.args()...)
+			if err != nil {
+				return fmt.Errorf("building %s in %s failed: %v", b.output, tempDir, err)
+			}
+			sum, err := hashFile(toPath(tempDir, b.output))
+			if err != nil {
+				return err
+			}
+			sums[i] = append(sums[i], sum)
+		}
+	}
+
+	differ := 0
+	for i, b := range cmds {
+		if sums[0][i] == sums[1][i] {
+			fmt.Println(greenText("Reproducible"), b.output, "sha256:"+sums[0][i])
+		} else {
+			fmt.Println(yellowText("Differs"), b.output, "sha256:"+sums[0][i], "sha256:"+sums[1][i])
+			differ++
+		}
+	}
+	if differ > 0 {
+		return fmt.Errorf("%d of %d outputs are not reproducible", differ, len(cmds))
+	}
+	return nil
+}
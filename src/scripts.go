@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// hooks run automatically, other scripts only through gopkg run-script
+const (
+	hookPreBuild  = "pre-build"
+	hookPostBuild = "post-build"
+	hookPreTest   = "pre-test"
+	hookPostFetch = "post-fetch"
+)
+
+// setProjectEnv exports the env section of p to every command gopkg runs
+func setProjectEnv(p *gopkgCfg) error {
+	for k, v := range p.Env {
+		err := os.Setenv(k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runScript runs the script called name with sh, args are available
+// to it as $1, $2...
+func runScript(p *gopkgCfg, name string, args ...string) error {
+	script, ok := p.Scripts[name]
+	if !ok {
+		return errors.New("unknown script: " + name)
+	}
+	fmt.Println(greenText("Running"), name)
+	return runCommand(append([]string{"sh", "-c", script, name}, args...)...)
+}
+
+// hookError is a failed hook, gopkg exits with the hook's exit code
+type hookError struct {
+	name string
+	err  error
+}
+
+func (e *hookError) Error() string {
+	return e.name + " failed: " + e.err.Error()
+}
+
+// runHook runs the hook if p defines it
+func runHook(p *gopkgCfg, name string) error {
+	if _, ok := p.Scripts[name]; !ok {
+		return nil
+	}
+	err := runScript(p, name)
+	if err != nil {
+		return &hookError{name, err}
+	}
+	return nil
+}
+
+func exitCode(err error) int {
+	if e, ok := err.(*hookError); ok {
+		err = e.err
+	}
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() > 0 {
+		return e.ExitCode()
+	}
+	return 1
+}
+
+// fatal logs err and exits, with the exit code of the failed hook if
+// err comes from one
+func fatal(err error) {
+	log.Println(err)
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+type searchResult struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Description string   `json:"description,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Authors     []string `json:"authors,omitempty"`
+	Git         string   `json:"git"`
+	Registry    string   `json:"registry"`
+}
+
+func (pkg *registryPkg) matches(query string) bool {
+	query = strings.ToLower(query)
+	if strings.Contains(strings.ToLower(pkg.Name), query) ||
+		strings.Contains(strings.ToLower(pkg.Description), query) {
+		return true
+	}
+	for _, k := range pkg.Keywords {
+		if strings.Contains(strings.ToLower(k), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchRegistries returns the packages matching query, a package found
+// in several registries is taken from the first one like getDeps does
+func searchRegistries(query string) ([]searchResult, error) {
+	var results []searchResult
+	seen := map[string]bool{}
+	for _, reg := range registries {
+		dir, err := registryDir(reg)
+		if err != nil {
+			return nil, err
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), ".yaml") {
+				continue
+			}
+			pkg, err := loadRegistryPkg(toPath(dir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if seen[pkg.Name] || !pkg.matches(query) {
+				continue
+			}
+			seen[pkg.Name] = true
+			version := "-"
+			if rv, err := pkg.latestVersion(""); err == nil {
+				version = rv.Version
+			}
+			results = append(results, searchResult{
+				Name:        pkg.Name,
+				Version:     version,
+				Description: pkg.Description,
+				Keywords:    pkg.Keywords,
+				Authors:     pkg.Authors,
+				Git:         pkg.Git,
+				Registry:    reg,
+			})
+		}
+	}
+	return results, nil
+}
+
+func search(query string, asJSON bool) error {
+	// outside a project only the user config is used
+	var p *gopkgCfg
+	if root, err := findProjectRoot("."); err == nil {
+		p, err = loadCfg(root)
+		if err != nil {
+			return err
+		}
+	}
+	err := loadSources(p)
+	if err != nil {
+		return err
+	}
+	if len(registries) == 0 {
+		return errors.New("no registry configured")
+	}
+
+	results, err := searchRegistries(query)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		if results == nil {
+			results = []searchResult{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(results)
+	}
+	for _, r := range results {
+		fmt.Println(greenText(r.Name), r.Version, "["+r.Git+"]")
+		if r.Description != "" {
+			fmt.Println("  " + r.Description)
+		}
+		if len(r.Authors) > 0 {
+			fmt.Println("  - Authors:", strings.Join(r.Authors, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed version like 1.2.3 or v1.2.3-beta
+type semver struct {
+	major, minor, patch int
+	pre                 string
+	// number of parts given, 1.2 has 2
+	parts int
+}
+
+func parseVersion(s string) (semver, error) {
+	var v semver
+	orig := s
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	// build metadata may contain '-', so it goes first
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		v.pre = s[i+1:]
+		s = s[:i]
+		if v.pre == "" {
+			return v, errors.New("invalid version: " + orig)
+		}
+	}
+	nums := strings.Split(s, ".")
+	if s == "" || len(nums) > 3 {
+		return v, errors.New("invalid version: " + orig)
+	}
+	for i, n := range nums {
+		x, err := strconv.Atoi(n)
+		if err != nil || x < 0 {
+			return v, errors.New("invalid version: " + orig)
+		}
+		switch i {
+		case 0:
+			v.major = x
+		case 1:
+			v.minor = x
+		case 2:
+			v.patch = x
+		}
+	}
+	v.parts = len(nums)
+	return v, nil
+}
+
+func (v semver) String() string {
+	s := strconv.Itoa(v.major) + "." + strconv.Itoa(v.minor) + "." + strconv.Itoa(v.patch)
+	if v.pre != "" {
+		s += "-" + v.pre
+	}
+	return s
+}
+
+// compare returns -1, 0 or 1, a pre-release sorts before its release
+func (v semver) compare(o semver) int {
+	for _, d := range []int{v.major - o.major, v.minor - o.minor, v.patch - o.patch} {
+		if d < 0 {
+			return -1
+		} else if d > 0 {
+			return 1
+		}
+	}
+	switch {
+	case v.pre == o.pre:
+		return 0
+	case v.pre == "":
+		return 1
+	case o.pre == "":
+		return -1
+	}
+	return comparePre(v.pre, o.pre)
+}
+
+// comparePre compares pre-releases field by field, numeric fields by
+// value and before alphanumeric ones, so beta.2 < beta.10 < beta.x
+func comparePre(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, errX := strconv.Atoi(as[i])
+		y, errY := strconv.Atoi(bs[i])
+		switch {
+		case errX == nil && errY == nil:
+			if x != y {
+				if x < y {
+					return -1
+				}
+				return 1
+			}
+		case errX == nil:
+			return -1
+		case errY == nil:
+			return 1
+		case as[i] != bs[i]:
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// matchVersion reports whether v satisfies constraint. A constraint is a
+// space or comma separated list of: *, 1.2.3, 1.2 (any 1.2.x), ^1.2, ~1.2,
+// >=1.2, >1.2, <=1.2, <1.2 and =1.2.3. Pre-releases only match exactly.
+func matchVersion(v semver, constraint string) (bool, error) {
+	fields := strings.FieldsFunc(constraint, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(fields) == 0 {
+		return v.pre == "", nil
+	}
+	for _, c := range fields {
+		ok, err := matchOne(v, c)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchOne(v semver, c string) (bool, error) {
+	if c == "*" || c == "x" {
+		return v.pre == "", nil
+	}
+	op := ""
+	for _, o := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(c, o) {
+			op = o
+			c = c[len(o):]
+			break
+		}
+	}
+	want, err := parseVersion(c)
+	if err != nil {
+		return false, err
+	}
+	if v.pre != "" && (want.pre == "" || op != "" && op != "=") {
+		return false, nil
+	}
+	cmp := v.compare(want)
+	switch op {
+	case ">=":
+		return cmp >= 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "^":
+		if cmp < 0 {
+			return false, nil
+		}
+		if want.major > 0 || want.parts == 1 {
+			return v.major == want.major, nil
+		}
+		if want.minor > 0 || want.parts == 2 {
+			return v.major == 0 && v.minor == want.minor, nil
+		}
+		return cmp == 0, nil
+	case "~":
+		if cmp < 0 {
+			return false, nil
+		}
+		if want.parts == 1 {
+			return v.major == want.major, nil
+		}
+		return v.major == want.major && v.minor == want.minor, nil
+	}
+	// 1.2 matches any 1.2.x
+	switch want.parts {
+	case 1:
+		return v.major == want.major, nil
+	case 2:
+		return v.major == want.major && v.minor == want.minor, nil
+	}
+	return cmp == 0, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  semver
+		isErr bool
+	}{
+		{in: "1.2.3", want: semver{1, 2, 3, "", 3}},
+		{in: "v1.2.3", want: semver{1, 2, 3, "", 3}},
+		{in: " 1.2 ", want: semver{1, 2, 0, "", 2}},
+		{in: "1", want: semver{1, 0, 0, "", 1}},
+		{in: "1.0.0-rc.1", want: semver{1, 0, 0, "rc.1", 3}},
+		{in: "1.0.0+build-5", want: semver{1, 0, 0, "", 3}},
+		{in: "1.0.0-rc+meta", want: semver{1, 0, 0, "rc", 3}},
+		{in: "1.0.0-beta-2+exp.sha.5114f85", want: semver{1, 0, 0, "beta-2", 3}},
+		{in: "", isErr: true},
+		{in: "1.2.3.4", isErr: true},
+		{in: "1.x", isErr: true},
+		{in: "1.-1", isErr: true},
+		{in: "1.0.0-", isErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseVersion(tt.in)
+		if tt.isErr {
+			if err == nil {
+				t.Errorf("parseVersion(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseVersion(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseVersion(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// each version sorts before the next one
+	order := []string{
+		"0.9.9",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.10",
+		"1.0.0-beta.x",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.1.0",
+		"2.0.0",
+	}
+	for i := range order {
+		for j := range order {
+			a, _ := parseVersion(order[i])
+			b, _ := parseVersion(order[j])
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := a.compare(b); got != want {
+				t.Errorf("compare(%s, %s) = %d, want %d", order[i], order[j], got, want)
+			}
+		}
+	}
+	a, _ := parseVersion("1.0.0+a")
+	b, _ := parseVersion("1.0.0+b")
+	if a.compare(b) != 0 {
+		t.Errorf("build metadata must not change the order")
+	}
+}
+
+func TestMatchOne(t *testing.T) {
+	tests := []struct {
+		v, c string
+		want bool
+	}{
+		{"1.2.3", "*", true},
+		{"1.2.3-rc.1", "*", false},
+		{"1.2.3", "1.2.3", true},
+		{"1.2.4", "1.2.3", false},
+		{"1.2.9", "1.2", true},
+		{"1.3.0", "1.2", false},
+		{"1.9.0", "1", true},
+		{"1.2.3", "=1.2.3", true},
+
+		{"1.2.3", "^1.2", true},
+		{"1.9.0", "^1.2", true},
+		{"2.0.0", "^1.2", false},
+		{"1.1.9", "^1.2", false},
+		{"0.2.5", "^0.2", true},
+		{"0.3.0", "^0.2", false},
+		{"0.2.3", "^0.2.3", true},
+		{"0.2.4", "^0.2.3", true},
+		{"0.0.3", "^0.0.3", true},
+		{"0.0.4", "^0.0.3", false},
+		{"0.9.0", "^0", true},
+		{"1.0.0", "^0", false},
+
+		{"1.2.9", "~1.2.3", true},
+		{"1.2.2", "~1.2.3", false},
+		{"1.3.0", "~1.2.3", false},
+		{"1.2.0", "~1.2", true},
+		{"1.9.0", "~1", true},
+		{"2.0.0", "~1", false},
+
+		{"1.2.0", ">=1.2", true},
+		{"1.1.9", ">=1.2", false},
+		{"1.2.1", ">1.2.0", true},
+		{"1.2.0", ">1.2.0", false},
+		{"1.2.0", "<=1.2.0", true},
+		{"1.2.1", "<=1.2.0", false},
+		{"1.9.9", "<2", true},
+		{"2.0.0", "<2", false},
+
+		{"1.0.0-rc.1", "1.0.0-rc.1", true},
+		{"1.0.0-rc.1", "=1.0.0-rc.1", true},
+		{"1.0.0-rc.2", "1.0.0-rc.1", false},
+		{"1.0.0-rc.1", "^1.0.0-rc.1", false},
+		{"1.0.0-rc.1", ">=0.9", false},
+		{"1.0.0-rc.1", "1.0", false},
+	}
+	for _, tt := range tests {
+		v, err := parseVersion(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := matchOne(v, tt.c)
+		if err != nil {
+			t.Errorf("matchOne(%s, %q): %v", tt.v, tt.c, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("matchOne(%s, %q) = %v, want %v", tt.v, tt.c, got, tt.want)
+		}
+	}
+	if _, err := matchOne(semver{}, "^x"); err == nil {
+		t.Errorf("matchOne with a bad constraint must fail")
+	}
+}
+
+func TestMatchVersionRange(t *testing.T) {
+	tests := []struct {
+		v, c string
+		want bool
+	}{
+		{"1.5.0", ">=1.2, <2", true},
+		{"1.5.0", ">=1.2 <2", true},
+		{"2.0.0", ">=1.2, <2", false},
+		{"1.1.0", ">=1.2, <2", false},
+		{"1.5.0", "", true},
+		{"1.5.0-rc.1", "", false},
+	}
+	for _, tt := range tests {
+		v, _ := parseVersion(tt.v)
+		got, err := matchVersion(v, tt.c)
+		if err != nil || got != tt.want {
+			t.Errorf("matchVersion(%s, %q) = %v, %v, want %v", tt.v, tt.c, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// stampData is available to the templates of the stamp section:
+//
+//	stamp:
+//	  main.version: "{{.Version}}"
+//	  main.commit: "{{.Commit}}{{if .Dirty}}-dirty{{end}}"
+type stampData struct {
+	Name        string
+	Version     string
+	Tag         string
+	Commit      string
+	ShortCommit string
+	Dirty       bool
+	BuildTime   string
+}
+
+func newStampData(p *gopkgCfg) *stampData {
+	d := &stampData{
+		Name:    p.Name,
+		Version: p.Version,
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		sec, err := strconv.ParseInt(epoch, 10, 64)
+		if err == nil {
+			d.BuildTime = time.Unix(sec, 0).UTC().Format(time.RFC3339)
+		}
+	} else if !reproducible {
+		d.BuildTime = time.Now().UTC().Format(time.RFC3339)
+	}
+	if len(p.Stamp) == 0 {
+		return d
+	}
+	// not being in a git repository is fine, the fields stay empty
+	d.Commit, _ = quietOutput("", "git", "rev-parse", "HEAD")
+	if len(d.Commit) >= 7 {
+		d.ShortCommit = d.Commit[:7]
+	}
+	if d.Commit != "" {
+		tags, _ := quietOutput("", "git", "tag", "--points-at", "HEAD")
+		// HEAD has no tag between releases, Tag stays empty then
+		if fields := strings.Fields(tags); len(fields) > 0 {
+			d.Tag = fields[0]
+		}
+		status, _ := quietOutput("", "git", "status", "--porcelain", "--untracked-files=no")
+		d.Dirty = status != ""
+	}
+	return d
+}
+
+// stampFlags returns the -X linker flags of the stamp section
+func stampFlags(p *gopkgCfg, data *stampData) (string, error) {
+	if len(p.Stamp) == 0 {
+		return "", nil
+	}
+	vars := make([]string, 0, len(p.Stamp))
+	for v := range p.Stamp {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+
+	var flags []string
+	for _, v := range vars {
+		tmpl, err := template.New(v).Parse(p.Stamp[v])
+		if err != nil {
+			return "", fmt.Errorf("stamp %s: %v", v, err)
+		}
+		var buf bytes.Buffer
+		err = tmpl.Execute(&buf, data)
+		if err != nil {
+			return "", fmt.Errorf("stamp %s: %v", v, err)
+		}
+		flags = append(flags, "-X", ldflagQuote(v+"="+buf.String()))
+	}
+	return strings.Join(flags, " "), nil
+}
+
+// ldflagQuote quotes s for the go tool's -ldflags parser,
+// which knows no escapes inside quotes
+func ldflagQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"") {
+		return s
+	}
+	if strings.Contains(s, "'") {
+		return `"` + s + `"`
+	}
+	return "'" + s + "'"
+}
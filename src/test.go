@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type testOpts struct {
+	deps     bool
+	run      string
+	cover    bool
+	race     bool
+	count    int
+	verbose  bool
+	coverOut string
+	htmlOut  string
+	reports  reportFlags
+}
+
+// testResult is the outcome of go test for one package
+type testResult struct {
+	dir      string
+	status   string
+	elapsed  time.Duration
+	coverage float64
+	// cover profile, removed after merging
+	profile string
+	// parsed go test -json output, with -report only
+	report *pkgReport
+}
+
+// testPackages returns the directories under src holding Go packages,
+// src/packages only if deps is set. A pattern like foo/... limits the
+// search to src/foo.
+func testPackages(patterns []string, deps bool) ([]string, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"..."}
+	}
+	var dirs []string
+	for _, pattern := range patterns {
+		root := toPath("src", filepath.FromSlash(pattern))
+		if pattern != "..." && !strings.HasSuffix(pattern, "/...") {
+			if !dirExists(root) {
+				return nil, errors.New("no such package: " + root)
+			}
+			dirs = append(dirs, root)
+			continue
+		}
+		root = strings.TrimSuffix(strings.TrimSuffix(root, "..."), string(os.PathSeparator))
+		err := filepath.Walk(root, func(p string, f os.FileInfo, err error) error {
+			if f == nil {
+				return err
+			}
+			if !f.IsDir() {
+				return nil
+			}
+			name := f.Name()
+			if p != root && (name == "testdata" || name[0] == '.' || name[0] == '_') {
+				return filepath.SkipDir
+			}
+			if !deps && p == toPath("src", "packages") {
+				return filepath.SkipDir
+			}
+			if matches, _ := filepath.Glob(toPath(p, "*.go")); len(matches) > 0 {
+				dirs = append(dirs, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dirs, nil
+}
+
+func hasTests(dir string) bool {
+	matches, _ := filepath.Glob(toPath(dir, "*_test.go"))
+	return len(matches) > 0
+}
+
+// goTestArgs returns the go test invocation for dir
+func (o *testOpts) goTestArgs(dir, profile string) []string {
+	args := append([]string{"go", "test"}, tagsFlag()...)
+	if o.verbose {
+		args = append(args, "-v")
+	}
+	if o.run != "" {
+		args = append(args, "-run", o.run)
+	}
+	if o.race {
+		args = append(args, "-race")
+	}
+	if len(o.reports) > 0 {
+		args = append(args, "-json")
+	}
+	if o.count > 0 {
+		args = append(args, "-count", strconv.Itoa(o.count))
+	}
+	if profile != "" {
+		args = append(args, "-coverprofile", profile)
+	}
+	return append(args, toPath(".", dir))
+}
+
+func runTests(dirs []string, o *testOpts) ([]testResult, error) {
+	var results []testResult
+	tempDir := toPath(os.TempDir(), "gopkg-"+randomStr())
+	defer os.RemoveAll(tempDir)
+	err := os.MkdirAll(tempDir, dirPerm)
+	if err != nil {
+		return nil, err
+	}
+	for i, dir := range dirs {
+		r := testResult{dir: dir}
+		if !hasTests(dir) {
+			r.status = "no tests"
+			results = append(results, r)
+			continue
+		}
+		if o.cover {
+			r.profile = toPath(tempDir, strconv.Itoa(i)+".out")
+		}
+		start := time.Now()
+		if len(o.reports) > 0 {
+			r.report, err = runTestJSON(dir, o.goTestArgs(dir, r.profile))
+			if err != nil {
+				return nil, err
+			}
+			if r.report.Status == "fail" {
+				err = errors.New("tests failed")
+			}
+		} else {
+			err = runCommand(o.goTestArgs(dir, r.profile)...)
+		}
+		r.elapsed = time.Since(start)
+		r.status = "ok"
+		if err != nil {
+			r.status = "FAIL"
+		}
+		if o.cover && fileExists(r.profile) {
+			r.coverage, err = profileCoverage(r.profile)
+			if err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, r)
+	}
+	if o.cover {
+		err = mergeProfiles(results, o.coverOut)
+		if err != nil {
+			return nil, err
+		}
+		err = runCommand("go", "tool", "cover", "-html="+o.coverOut, "-o", o.htmlOut)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println(greenText("Coverage"), o.coverOut, o.htmlOut)
+	}
+	return results, nil
+}
+
+// profileCoverage returns the percentage of statements covered
+func profileCoverage(profile string) (float64, error) {
+	file, err := os.Open(profile)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var total, covered int
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		// name.go:line.column,line.column statements count
+		fields := strings.Fields(s.Text())
+		if len(fields) != 3 || strings.HasPrefix(s.Text(), "mode:") {
+			continue
+		}
+		stmts, _ := strconv.Atoi(fields[1])
+		count, _ := strconv.Atoi(fields[2])
+		total += stmts
+		if count > 0 {
+			covered += stmts
+		}
+	}
+	if total == 0 {
+		return 0, s.Err()
+	}
+	return 100 * float64(covered) / float64(total), s.Err()
+}
+
+// mergeProfiles writes the cover profiles of results into one file
+func mergeProfiles(results []testResult, out string) error {
+	var merged []string
+	for _, r := range results {
+		if r.profile == "" || !fileExists(r.profile) {
+			continue
+		}
+		buf, err := ioutil.ReadFile(r.profile)
+		if err != nil {
+			return err
+		}
+		lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+		if len(merged) == 0 {
+			merged = append(merged, lines[0])
+		}
+		merged = append(merged, lines[1:]...)
+	}
+	if len(merged) == 0 {
+		return errors.New("no coverage data")
+	}
+	err := os.MkdirAll(filepath.Dir(out), dirPerm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(out, []byte(strings.Join(merged, "\n")+"\n"), filePerm)
+}
+
+// printTestSummary prints a table of results and returns how many failed
+func printTestSummary(results []testResult, cover bool) int {
+	width := len("PACKAGE")
+	for _, r := range results {
+		if len(r.dir) > width {
+			width = len(r.dir)
+		}
+	}
+	fmt.Println()
+	header := fmt.Sprintf("%-*s  %-8s  %8s", width, "PACKAGE", "STATUS", "TIME")
+	if cover {
+		header += "  COVERAGE"
+	}
+	fmt.Println(header)
+	failed := 0
+	for _, r := range results {
+		status := fmt.Sprintf("%-8s", r.status)
+		switch r.status {
+		case "ok":
+			status = greenText(status)
+		case "FAIL":
+			status = yellowText(status)
+			failed++
+		}
+		line := fmt.Sprintf("%-*s  %s  %7.2fs", width, r.dir, status, r.elapsed.Seconds())
+		if cover && r.status != "no tests" {
+			line += fmt.Sprintf("  %7.1f%%", r.coverage)
+		}
+		fmt.Println(line)
+	}
+	return failed
+}
+
+func testCommand(args []string) error {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	var o testOpts
+	fs.BoolVar(&o.deps, "deps", false, "also test src/packages")
+	fs.StringVar(&o.run, "run", "", "run only tests matching regexp")
+	fs.BoolVar(&o.cover, "cover", false, "write a merged coverage profile and HTML report")
+	fs.BoolVar(&o.race, "race", false, "enable the race detector")
+	fs.IntVar(&o.count, "count", 0, "run each test n times")
+	fs.BoolVar(&o.verbose, "v", false, "verbose output")
+	fs.StringVar(&o.coverOut, "coverprofile", toPath(".gopkg", "coverage.out"), "merged coverage profile")
+	fs.StringVar(&o.htmlOut, "coverhtml", toPath(".gopkg", "coverage.html"), "coverage HTML report")
+	fs.Var(&o.reports, "report", "write a junit=file or json=file report, may be repeated")
+	features := fs.String("features", "", "comma separated features to enable")
+	fs.Parse(args)
+	projectFeatures = parseFeatures(*features)
+
+	p, err := getDeps(".")
+	if err != nil {
+		return err
+	}
+	err = runHook(p, hookPreTest)
+	if err != nil {
+		return err
+	}
+	dirs, err := testPackages(fs.Args(), o.deps)
+	if err != nil {
+		return err
+	}
+	results, err := runTests(dirs, &o)
+	if err != nil {
+		return err
+	}
+	err = writeReports(o.reports, results)
+	if err != nil {
+		return err
+	}
+	if failed := printTestSummary(results, o.cover); failed > 0 {
+		return fmt.Errorf("%d of %d packages failed", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	watchInterval = 500 * time.Millisecond
+	// wait for this long without changes before acting
+	watchDebounce = 300 * time.Millisecond
+)
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshot returns the files gopkg watch looks at
+func snapshot() map[string]fileStamp {
+	files := map[string]fileStamp{}
+	if fi, err := os.Stat("gopkg.yaml"); err == nil {
+		files["gopkg.yaml"] = fileStamp{fi.ModTime(), fi.Size()}
+	}
+	filepath.Walk("src", func(p string, f os.FileInfo, err error) error {
+		if f == nil || f.IsDir() {
+			return nil
+		}
+		files[p] = fileStamp{f.ModTime(), f.Size()}
+		return nil
+	})
+	return files
+}
+
+// changed returns the files added, removed or modified between a and b
+func changed(a, b map[string]fileStamp) []string {
+	var files []string
+	for p, s := range b {
+		if old, ok := a[p]; !ok || old != s {
+			files = append(files, p)
+		}
+	}
+	for p := range a {
+		if _, ok := b[p]; !ok {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// waitChange blocks until files differ from last and stay unchanged for
+// watchDebounce, it returns the changed files
+func waitChange(last map[string]fileStamp) []string {
+	for {
+		time.Sleep(watchInterval)
+		cur := snapshot()
+		files := changed(last, cur)
+		if len(files) == 0 {
+			continue
+		}
+		for {
+			time.Sleep(watchDebounce)
+			next := snapshot()
+			more := changed(cur, next)
+			if len(more) == 0 {
+				return changed(last, next)
+			}
+			cur = next
+		}
+	}
+}
+
+// gopkgCmd runs gopkg itself, which exits on most errors
+func gopkgCmd(args ...string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return runCommand(append([]string{exe}, args...)...)
+}
+
+// stopProcess interrupts cmd and kills it if it has not exited in time
+func stopProcess(cmd *exec.Cmd, done chan error) {
+	if cmd == nil {
+		return
+	}
+	select {
+	case <-done:
+		return
+	default:
+	}
+	cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+func watch(args []string) error {
+	mode := "build"
+	if len(args) > 0 {
+		mode, args = args[0], args[1:]
+	}
+	if mode != "build" && mode != "test" && mode != "run" {
+		return errors.New("usage: gopkg watch [build|test|run] [args]")
+	}
+
+	// the running program of watch run
+	var (
+		mu   sync.Mutex
+		prog *exec.Cmd
+		done chan error
+	)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		mu.Lock()
+		stopProcess(prog, done)
+		os.Exit(130)
+	}()
+
+	cfgChanged := false
+	for {
+		if cfgChanged {
+			fmt.Println(greenText("Syncing"), "dependencies")
+			_, err := getDeps(".")
+			if err != nil {
+				fmt.Println(yellowText("Error"), err)
+			}
+		}
+		// taken before the command so that files saved while it runs
+		// trigger the next round
+		last := snapshot()
+		switch mode {
+		case "build", "test":
+			err := gopkgCmd(append([]string{mode}, args...)...)
+			if err != nil {
+				fmt.Println(yellowText("Failed"), err)
+			}
+		case "run":
+			mu.Lock()
+			stopProcess(prog, done)
+			prog = nil
+			mu.Unlock()
+			p, err := loadCfg(".")
+			if err != nil {
+				fmt.Println(yellowText("Error"), err)
+				break
+			}
+			bn, progArgs, err := pickBin(p, args)
+			if err != nil {
+				fmt.Println(yellowText("Error"), err)
+				break
+			}
+			err = gopkgCmd("build", bn.Name)
+			if err != nil {
+				fmt.Println(yellowText("Failed"), err)
+				break
+			}
+			cmd := exec.Command(toPath(".", bn.Name), progArgs...)
+			cmd.Stdin = os.Stdin
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			err = cmd.Start()
+			if err != nil {
+				fmt.Println(yellowText("Error"), err)
+				break
+			}
+			mu.Lock()
+			prog, done = cmd, make(chan error, 1)
+			go func(done chan error) {
+				done <- cmd.Wait()
+			}(done)
+			mu.Unlock()
+		}
+
+		fmt.Println(greenText("Watching"), "src and gopkg.yaml for changes")
+		files := waitChange(last)
+		cfgChanged = false
+		for _, f := range files {
+			if f == "gopkg.yaml" {
+				cfgChanged = true
+			}
+		}
+		fmt.Println(greenText("Changed"), files[0], fmt.Sprintf("(%d files)", len(files)))
+	}
+}
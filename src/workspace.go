@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"packages/yaml"
+)
+
+// workspace is a set of gopkg projects sharing one packages directory,
+// described by gopkg-workspace.yaml
+type workspace struct {
+	Members []string `yaml:"members"`
+
+	root string
+}
+
+const workspaceFile = "gopkg-workspace.yaml"
+
+// memberEnv is set for the gopkg run in each member by fanOut, so that a
+// member at the workspace root builds itself instead of fanning out again
+const memberEnv = "GOPKG_WORKSPACE_MEMBER"
+
+// current is the workspace of the project being worked on, if any
+var current *workspace
+
+// packagesDir is where dependencies are installed, src/packages of the
+// project or of the workspace it belongs to
+var packagesDir = toPath("src", "packages")
+
+func loadWorkspace(dir string) (*workspace, error) {
+	buf, err := ioutil.ReadFile(toPath(dir, workspaceFile))
+	if err != nil {
+		return nil, err
+	}
+	w := new(workspace)
+	err = yaml.Unmarshal(buf, w)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", toPath(dir, workspaceFile), err)
+	}
+	w.root, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// findWorkspace returns the workspace above root that lists it as a
+// member, or nil if there is none
+func findWorkspace(root string) (*workspace, error) {
+	for dir := root; ; dir = filepath.Dir(dir) {
+		if fileExists(toPath(dir, workspaceFile)) {
+			w, err := loadWorkspace(dir)
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range w.memberDirs() {
+				if m == root {
+					return w, nil
+				}
+			}
+		}
+		if filepath.Dir(dir) == dir {
+			return nil, nil
+		}
+	}
+}
+
+func (w *workspace) memberDirs() []string {
+	dirs := make([]string, len(w.Members))
+	for i, m := range w.Members {
+		dirs[i] = toPath(w.root, filepath.FromSlash(m))
+	}
+	return dirs
+}
+
+// member returns the directory of the member whose gopkg.yaml is named
+// name, or "" if there is none
+func (w *workspace) member(name string) (string, error) {
+	for _, dir := range w.memberDirs() {
+		p, err := loadCfg(dir)
+		if err != nil {
+			return "", err
+		}
+		if p.Name == name {
+			return dir, nil
+		}
+	}
+	return "", nil
+}
+
+// gopath puts the project first so that its own src/packages, if any,
+// wins over the shared one
+func (w *workspace) gopath(root string) string {
+	return root + string(os.PathListSeparator) + w.root
+}
+
+// useWorkspace makes the project at root install its dependencies into
+// its workspace, if it belongs to one
+func useWorkspace(root string) error {
+	w, err := findWorkspace(root)
+	if err != nil || w == nil {
+		return err
+	}
+	current = w
+	packagesDir = toPath(w.root, "src", "packages")
+	installedFile = toPath(w.root, ".gopkg", "packages.yaml")
+	return os.Setenv("GOPATH", w.gopath(root))
+}
+
+// linkMember makes the workspace member at dir importable as
+// packages/<name>
+func linkMember(name, dir string) error {
+	err := os.MkdirAll(packagesDir, dirPerm)
+	if err != nil {
+		return err
+	}
+	target, err := filepath.Rel(packagesDir, toPath(dir, "src"))
+	if err != nil {
+		return err
+	}
+	fmt.Println(greenText("Linking"), name, "["+dir+"]")
+	return os.Symlink(target, toPath(packagesDir, name))
+}
+
+// fanOut runs a gopkg command in every member of the workspace at the
+// current directory
+func fanOut(command string, args []string) error {
+	w, err := loadWorkspace(".")
+	if err != nil {
+		return err
+	}
+	if len(w.Members) == 0 {
+		return errors.New(workspaceFile + ": no members")
+	}
+	err = os.Setenv(memberEnv, "1")
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for i, dir := range w.memberDirs() {
+		fmt.Println(greenText("Member"), w.Members[i])
+		err = os.Chdir(dir)
+		if err != nil {
+			return err
+		}
+		err = gopkgCmd(append([]string{command}, args...)...)
+		if err != nil {
+			failed++
+		}
+	}
+	os.Chdir(w.root)
+	if failed > 0 {
+		return fmt.Errorf("%s failed in %d of %d members", command, failed, len(w.Members))
+	}
+	return nil
+}